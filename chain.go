@@ -0,0 +1,457 @@
+package errors
+
+import (
+	"bytes"
+	baseErrors "errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// walk calls fn for err and each error in its chain, depth first, following
+// both Unwrap() error and Unwrap() []error (as returned by Join). Walking
+// stops early if fn returns false. walk reports whether it ran to completion.
+// An error that unwraps to one of its own ancestors is visited once, so a
+// cycle in the chain ends the walk of that branch rather than hanging. Errors
+// that cannot be compared can't be recognised as ancestors, so walking also
+// stops after visiting maxChainErrors errors.
+func walk(err error, fn func(error) bool) bool {
+	budget := maxChainErrors
+	return walkPath(err, fn, nil, &budget)
+}
+
+// maxChainErrors bounds how many errors are visited in one pass over a chain,
+// ending cycles through errors that cannot be compared, which seenBefore
+// cannot detect. It bounds the total rather than the depth, as a cycle through
+// a Join has exponentially many paths.
+const maxChainErrors = 1000
+
+func walkPath(err error, fn func(error) bool, ancestors []error, budget *int) bool {
+	if err == nil || *budget <= 0 || seenBefore(err, ancestors) {
+		return true
+	}
+	*budget--
+
+	if !fn(err) {
+		return false
+	}
+	ancestors = append(ancestors, err)
+
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return walkPath(e.Unwrap(), fn, ancestors, budget)
+	case interface{ Unwrap() []error }:
+		for _, child := range e.Unwrap() {
+			if !walkPath(child, fn, ancestors, budget) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// seenBefore reports whether err is identical to any of seen.
+func seenBefore(err error, seen []error) bool {
+	for _, e := range seen {
+		if identical(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnwrapDeep returns the next error in err's chain. Unlike errors.Unwrap,
+// which returns nil for a Join, UnwrapDeep descends into the first joined
+// error, so every node has at most one successor. It returns nil if err does
+// not wrap another error.
+func UnwrapDeep(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Unwrap() []error }:
+		if errs := e.Unwrap(); len(errs) > 0 {
+			return errs[0]
+		}
+	}
+	return nil
+}
+
+// Types returns the distinct type names of every error in err's chain,
+// including the branches of a Join, in order of first appearance.
+func Types(err error) []string {
+	var types []string
+	seen := map[string]bool{}
+
+	walk(err, func(e error) bool {
+		name := typeName(e)
+		if !seen[name] {
+			seen[name] = true
+			types = append(types, name)
+		}
+		return true
+	})
+
+	return types
+}
+
+// FlatMessage returns the messages of the errors joined in err's chain,
+// separated by "; ", e.g. "error A; error B". Nested Joins are flattened and
+// duplicate messages are included once. Messages of errors wrapping a Join are
+// not included, as each joined error has its own message. An error with no
+// Join in its chain gives its own Error().
+func FlatMessage(err error) string {
+	var messages []string
+	seen := map[string]bool{}
+
+	budget := maxChainErrors
+	var flatten func(error, []error)
+	flatten = func(err error, ancestors []error) {
+		if budget <= 0 || seenBefore(err, ancestors) {
+			return
+		}
+		budget--
+		if joined := joinIn(err); joined != nil {
+			for _, child := range joined.Unwrap() {
+				flatten(child, append(ancestors, err, joined.(error)))
+			}
+			return
+		}
+		if message := err.Error(); !seen[message] {
+			seen[message] = true
+			messages = append(messages, message)
+		}
+	}
+	if err != nil {
+		flatten(err, nil)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// joinIn returns the first Join in err's chain, found by repeatedly calling
+// Unwrap() error, or nil if there is none or the chain has a cycle.
+func joinIn(err error) interface{ Unwrap() []error } {
+	var seen []error
+	for err != nil && len(seen) < maxChainErrors && !seenBefore(err, seen) {
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			return e
+		case interface{ Unwrap() error }:
+			seen = append(seen, err)
+			err = e.Unwrap()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Cause returns the deepest error in err's chain, found by repeatedly calling
+// Unwrap() error. A Join has several causes, so unwrapping stops there. If the
+// chain has a cycle, unwrapping stops at the error that leads back to one
+// already seen, or after maxChainErrors errors if they cannot be compared.
+func Cause(err error) error {
+	var seen []error
+	for {
+		e, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return err
+		}
+		next := e.Unwrap()
+		seen = append(seen, err)
+		if next == nil || len(seen) >= maxChainErrors || seenBefore(next, seen) {
+			return err
+		}
+		err = next
+	}
+}
+
+// ShareCause reports whether the Causes of a and b are the same error, either
+// because they are identical or because one matches the other with errors.Is.
+// It returns false if either is nil.
+func ShareCause(a, b error) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	aCause, bCause := Cause(a), Cause(b)
+	return baseErrors.Is(aCause, bCause) || baseErrors.Is(bCause, aCause)
+}
+
+// CommonAncestor returns the deepest error in a's chain that is also in b's
+// chain, either because it is identical to an error there or because one
+// reports Is of the other. This finds the shared root of two errors that were
+// wrapped along different paths. With Joins, the deepest is the last found in
+// the order errors.Is would test them. It returns nil if there is no such
+// error or either is nil. Cycles in either chain are not followed.
+func CommonAncestor(a, b error) error {
+	var others []error
+	walk(b, func(e error) bool {
+		others = append(others, e)
+		return true
+	})
+
+	var ancestor error
+	walk(a, func(e error) bool {
+		for _, other := range others {
+			if identical(e, other) || reportsIs(e, other) || reportsIs(other, e) {
+				ancestor = e
+				break
+			}
+		}
+		return true
+	})
+
+	return ancestor
+}
+
+// reportsIs reports whether err has an Is method that matches target.
+func reportsIs(err, target error) bool {
+	is, ok := err.(interface{ Is(error) bool })
+	return ok && is.Is(target)
+}
+
+// IsFunc reports whether pred returns true for any error in err's chain,
+// including the branches of a Join, in the order errors.Is would test them. It
+// stops at the first match.
+func IsFunc(err error, pred func(error) bool) bool {
+	return !walk(err, func(e error) bool {
+		return !pred(e)
+	})
+}
+
+// IfType calls fn with the first error in err's chain of type T, as found by
+// errors.As, and reports whether there was one.
+func IfType[T error](err error, fn func(T)) bool {
+	var target T
+	if !baseErrors.As(err, &target) {
+		return false
+	}
+	fn(target)
+	return true
+}
+
+// AsAll returns every error in err's chain of type T, including those in the
+// branches of a Join, in the order errors.As would test them. Unlike
+// errors.As, As methods are not consulted.
+func AsAll[T error](err error) []T {
+	var matches []T
+
+	walk(err, func(e error) bool {
+		if match, ok := e.(T); ok {
+			matches = append(matches, match)
+		}
+		return true
+	})
+
+	return matches
+}
+
+// WouldMatch reports whether errors.Is(err, target). See Explain for why.
+func WouldMatch(err, target error) bool {
+	return baseErrors.Is(err, target)
+}
+
+// Explain describes why errors.Is(err, target) succeeds or fails, to help
+// debug test failures. For a match it names the error in err's chain that
+// matched. Otherwise it lists each error in the chain, in the order errors.Is
+// tests them, with the reason it did not match target.
+func Explain(err, target error) string {
+	if err == nil || target == nil {
+		switch {
+		case err == target:
+			return "match: err and target are both nil"
+		case err == nil:
+			return "no match: err is nil"
+		default:
+			return "no match: target is nil"
+		}
+	}
+
+	var explanation string
+	var reasons []string
+	walk(err, func(e error) bool {
+		label := fmt.Sprintf("%s %q", typeName(e), e.Error())
+		if identical(e, target) {
+			explanation = "match: " + label + " is target"
+			return false
+		}
+		if is, ok := e.(interface{ Is(error) bool }); ok && is.Is(target) {
+			explanation = "match: " + label + " reports Is(target)"
+			return false
+		}
+
+		reason := "different value of the same type"
+		if reflect.TypeOf(e) != reflect.TypeOf(target) {
+			reason = "type differs from target type " + typeName(target)
+		} else if !reflect.ValueOf(e).Comparable() {
+			reason = "type is not comparable"
+		}
+		if _, ok := e.(interface{ Is(error) bool }); ok {
+			reason += ", and Is(target) is false"
+		}
+		reasons = append(reasons, "\t"+label+": "+reason+"\n")
+		return true
+	})
+
+	if explanation != "" {
+		return explanation
+	}
+	return "no match:\n" + strings.Join(reasons, "")
+}
+
+// IsMessage reports whether any error in err's chain, including the branches
+// of a Join, has the message msg. It is a last resort for matching errors from
+// libraries that provide neither sentinels nor types to match with errors.Is
+// or errors.As, as messages are liable to change.
+func IsMessage(err error, msg string) bool {
+	return !walk(err, func(e error) bool {
+		return e.Error() != msg
+	})
+}
+
+// ChainStack returns the type, message and callstack of every *Error in err's
+// chain, outermost first. When timestamps were captured (see
+// CaptureTimestamps) each layer also shows the time elapsed since the next
+// *Error it wraps was created, showing where latency accrued.
+func ChainStack(err error) string {
+	buf := bytes.Buffer{}
+
+	walk(err, func(e error) bool {
+		layer, ok := e.(*Error)
+		if !ok {
+			return true
+		}
+
+		buf.WriteString(layer.TypeName() + " " + layer.Error())
+		if inner := innerError(layer); inner != nil && !layer.created.IsZero() && !inner.created.IsZero() {
+			fmt.Fprintf(&buf, " (+%dms since inner)", layer.created.Sub(inner.created).Milliseconds())
+		}
+		buf.WriteString("\n")
+		buf.Write(layer.Stack())
+		return true
+	})
+
+	return buf.String()
+}
+
+// innerError returns the next *Error wrapped by err, not descending into
+// Joins, or nil if there is none.
+func innerError(err *Error) *Error {
+	seen := []error{err}
+	for next := err.Err; next != nil && len(seen) < maxChainErrors && !seenBefore(next, seen); {
+		if e, ok := next.(*Error); ok {
+			return e
+		}
+		e, ok := next.(interface{ Unwrap() error })
+		if !ok {
+			return nil
+		}
+		seen = append(seen, next)
+		next = e.Unwrap()
+	}
+	return nil
+}
+
+// TotalStackDepth returns the sum of the StackDepth of every *Error in err's
+// chain, including the branches of a Join. Frames are not resolved.
+func TotalStackDepth(err error) int {
+	total := 0
+
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			total += e.StackDepth()
+		}
+		return true
+	})
+
+	return total
+}
+
+// CountErrors returns the number of *Error values in err's chain, including
+// the branches of a Join. Frames are not resolved.
+func CountErrors(err error) int {
+	count := 0
+
+	walk(err, func(e error) bool {
+		if _, ok := e.(*Error); ok {
+			count++
+		}
+		return true
+	})
+
+	return count
+}
+
+// TreeString renders err and its chain as a tree, one error per line, showing
+// the nesting of wraps and Joins. Each *Error is shown with its message and
+// the file:line of its origin. Cycles in the chain are marked rather than
+// followed, and at most maxChainErrors errors are shown.
+func (err *Error) TreeString() string {
+	buf := bytes.Buffer{}
+	budget := maxChainErrors
+	writeTree(&buf, err, "", "", nil, &budget)
+	return buf.String()
+}
+
+func writeTree(buf *bytes.Buffer, err error, first, rest string, ancestors []error, budget *int) {
+	if seenBefore(err, ancestors) {
+		buf.WriteString(first + "(cycle)\n")
+		return
+	}
+	if *budget <= 0 {
+		if *budget == 0 {
+			buf.WriteString(first + "(truncated)\n")
+		}
+		*budget--
+		return
+	}
+	*budget--
+	buf.WriteString(first + treeLabel(err) + "\n")
+	ancestors = append(ancestors, err)
+
+	var children []error
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		if child := e.Unwrap(); child != nil {
+			children = []error{child}
+		}
+	case interface{ Unwrap() []error }:
+		children = e.Unwrap()
+	}
+
+	for i, child := range children {
+		if i == len(children)-1 {
+			writeTree(buf, child, rest+"└─ ", rest+"   ", ancestors, budget)
+		} else {
+			writeTree(buf, child, rest+"├─ ", rest+"│  ", ancestors, budget)
+		}
+	}
+}
+
+// treeLabel describes a single error in a TreeString.
+func treeLabel(err error) string {
+	if _, ok := err.(interface{ Unwrap() []error }); ok {
+		return reflect.TypeOf(err).String()
+	}
+
+	label := reflect.TypeOf(err).String() + ": " + strings.Replace(err.Error(), "\n", "; ", -1)
+	if e, ok := err.(*Error); ok {
+		if frames := e.StackFrames(); len(frames) > 0 {
+			label += fmt.Sprintf(" (%s:%d)", filepath.Base(frames[0].displayFile()), frames[0].LineNumber)
+		}
+	}
+	return label
+}
+
+// identical reports whether a and b are the same error value, without
+// panicking on uncomparable errors.
+func identical(a, b error) bool {
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() || !reflect.ValueOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
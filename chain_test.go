@@ -0,0 +1,402 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypes(t *testing.T) {
+	if types := Types(nil); types != nil {
+		t.Errorf("Types of nil: %v", types)
+	}
+
+	err := errors.Join(
+		New(io.EOF),
+		fmt.Errorf("wrapped: %w", New(errorString("foo"))),
+		io.ErrUnexpectedEOF,
+	)
+
+	expected := []string{"*errors.joinError", "*errors.Error", "*errors.errorString", "*fmt.wrapError", "errors.errorString"}
+	if types := Types(err); !reflect.DeepEqual(types, expected) {
+		t.Errorf("Wrong types: %v", types)
+	}
+}
+
+type cyclicError struct {
+	next error
+}
+
+func (e *cyclicError) Error() string {
+	return "cyclic"
+}
+
+func (e *cyclicError) Unwrap() error {
+	return e.next
+}
+
+func TestTreeString(t *testing.T) {
+	leaf := &Error{Err: errorString("leaf"), frames: []StackFrame{{File: "/src/leaf.go", LineNumber: 7}}}
+	err := &Error{Err: errors.Join(leaf, fmt.Errorf("wrapped: %w", io.EOF)), frames: []StackFrame{{File: "/src/root.go", LineNumber: 3}}}
+
+	expected := "*errors.Error: leaf; wrapped: EOF (root.go:3)\n" +
+		"└─ *errors.joinError\n" +
+		"   ├─ *errors.Error: leaf (leaf.go:7)\n" +
+		"   │  └─ errors.errorString: leaf\n" +
+		"   └─ *fmt.wrapError: wrapped: EOF\n" +
+		"      └─ *errors.errorString: EOF\n"
+	if tree := err.TreeString(); tree != expected {
+		t.Errorf("Wrong tree:\n%s", tree)
+	}
+
+	cyclic := &cyclicError{}
+	cyclic.next = cyclic
+	expected = "*errors.Error: cyclic\n" +
+		"└─ *errors.cyclicError: cyclic\n" +
+		"   └─ (cycle)\n"
+	if tree := (&Error{Err: cyclic, frames: []StackFrame{}}).TreeString(); tree != expected {
+		t.Errorf("Wrong cyclic tree:\n%s", tree)
+	}
+}
+
+func TestCause(t *testing.T) {
+	if Cause(nil) != nil {
+		t.Errorf("Cause of nil not nil")
+	}
+
+	err := WrapPrefix(fmt.Errorf("wrapped: %w", io.EOF), "prefix", 0)
+	if Cause(err) != io.EOF {
+		t.Errorf("Wrong cause: %v", Cause(err))
+	}
+
+	joined := errors.Join(io.EOF)
+	if Cause(New(joined)) != joined {
+		t.Errorf("Cause unwrapped a Join")
+	}
+}
+
+func TestShareCause(t *testing.T) {
+	a := New(fmt.Errorf("reading: %w", io.EOF))
+	b := WrapPrefix(io.EOF, "prefix", 0)
+
+	if !ShareCause(a, b) {
+		t.Errorf("Errors with the same cause do not share it")
+	}
+	if ShareCause(a, New(io.ErrUnexpectedEOF)) {
+		t.Errorf("Errors with different causes share one")
+	}
+	if ShareCause(nil, nil) || ShareCause(a, nil) {
+		t.Errorf("nil shares a cause")
+	}
+}
+
+func TestChainStack(t *testing.T) {
+	inner := &Error{Err: io.EOF, frames: []StackFrame{{File: "inner.go", LineNumber: 1}}}
+	outer := &Error{Err: fmt.Errorf("reading: %w", inner), frames: []StackFrame{{File: "outer.go", LineNumber: 2}}}
+
+	expected := "*fmt.wrapError reading: EOF\n" + string(outer.Stack()) +
+		"*errors.errorString EOF\n" + string(inner.Stack())
+	if stack := ChainStack(outer); stack != expected {
+		t.Errorf("Wrong chain stack:\n%s", stack)
+	}
+
+	inner.created = time.Unix(100, 0)
+	outer.created = inner.created.Add(25 * time.Millisecond)
+	if stack := ChainStack(outer); !strings.HasPrefix(stack, "*fmt.wrapError reading: EOF (+25ms since inner)\n") {
+		t.Errorf("Elapsed time not shown:\n%s", stack)
+	}
+}
+
+func TestUnwrapDeep(t *testing.T) {
+	joined := errors.Join(io.EOF, io.ErrUnexpectedEOF)
+
+	if errors.Unwrap(joined) != nil || UnwrapDeep(joined) != io.EOF {
+		t.Errorf("UnwrapDeep did not descend into Join")
+	}
+
+	err := New(joined)
+	if UnwrapDeep(err) != joined {
+		t.Errorf("UnwrapDeep did not unwrap *Error")
+	}
+
+	if UnwrapDeep(io.EOF) != nil || UnwrapDeep(nil) != nil {
+		t.Errorf("UnwrapDeep unwrapped a leaf")
+	}
+}
+
+func TestTotalStackDepth(t *testing.T) {
+	a := New(io.EOF).(*Error)
+	b := New(io.ErrUnexpectedEOF).(*Error)
+	err := WrapPrefix(fmt.Errorf("wrapped: %w", errors.Join(a, b)), "prefix", 0).(*Error)
+
+	if total := TotalStackDepth(err); total != a.StackDepth()+b.StackDepth()+err.StackDepth() {
+		t.Errorf("Wrong total depth: %d", total)
+	}
+	if a.frames != nil || err.frames != nil {
+		t.Errorf("TotalStackDepth resolved frames")
+	}
+	if TotalStackDepth(io.EOF) != 0 {
+		t.Errorf("Plain error has a stack depth")
+	}
+}
+
+func TestCountErrors(t *testing.T) {
+	a := New(io.EOF).(*Error)
+	b := New(io.ErrUnexpectedEOF)
+	err := Wrap(fmt.Errorf("wrapped: %w", errors.Join(a, b, io.EOF)), 0)
+
+	if count := CountErrors(err); count != 3 {
+		t.Errorf("Wrong count: %d", count)
+	}
+	if a.frames != nil {
+		t.Errorf("CountErrors resolved frames")
+	}
+	if CountErrors(io.EOF) != 0 || CountErrors(nil) != 0 {
+		t.Errorf("Counted a plain error")
+	}
+	if CountErrors(Wrap(a, 0)) != 1 {
+		t.Errorf("Wrap of *Error double wrapped")
+	}
+}
+
+func TestFlatMessage(t *testing.T) {
+	err := Wrap(errors.Join(
+		New("error A"),
+		fmt.Errorf("error B: %w", io.EOF),
+		errors.Join(New("error C"), errors.New("error A")),
+	), 0)
+
+	if message := FlatMessage(err); message != "error A; error B: EOF; error C" {
+		t.Errorf("Wrong message: %q", message)
+	}
+	if message := FlatMessage(fmt.Errorf("reading: %w", io.EOF)); message != "reading: EOF" {
+		t.Errorf("Wrong message without Join: %q", message)
+	}
+	if FlatMessage(nil) != "" {
+		t.Errorf("Nil error has a message")
+	}
+}
+
+func TestIsMessage(t *testing.T) {
+	err := Wrap(fmt.Errorf("wrapped: %w", errors.Join(io.EOF, errors.New("connection reset"))), 0)
+
+	if !IsMessage(err, "connection reset") || !IsMessage(err, "EOF") || !IsMessage(err, err.Error()) {
+		t.Errorf("Message not found")
+	}
+	if IsMessage(err, "connection") || IsMessage(nil, "") {
+		t.Errorf("Wrong message matched")
+	}
+}
+
+func TestIsFunc(t *testing.T) {
+	err := Wrap(fmt.Errorf("wrapped: %w", errors.Join(io.EOF, errors.New("read timeout"), io.ErrClosedPipe)), 0)
+
+	var tested []error
+	timeout := func(e error) bool {
+		tested = append(tested, e)
+		return strings.HasSuffix(e.Error(), "timeout")
+	}
+	if !IsFunc(err, timeout) {
+		t.Errorf("Predicate not matched")
+	}
+	if len(tested) != 5 {
+		t.Errorf("Walk didn't stop at first match: %v", tested)
+	}
+
+	if IsFunc(err, func(e error) bool { return false }) || IsFunc(nil, func(e error) bool { return true }) {
+		t.Errorf("Wrong match")
+	}
+}
+
+type cyclicJoin struct {
+	errs []error
+}
+
+func (e *cyclicJoin) Error() string {
+	return "cyclic join"
+}
+
+func (e *cyclicJoin) Unwrap() []error {
+	return e.errs
+}
+
+// uncomparableError unwraps to itself, in a cycle that can't be detected by
+// comparing errors.
+type uncomparableError struct {
+	s []int
+}
+
+func (e uncomparableError) Error() string {
+	return "uncomparable"
+}
+
+func (e uncomparableError) Unwrap() error {
+	return e
+}
+
+// uncomparableJoin is a Join that cannot be compared.
+type uncomparableJoin []error
+
+func (e uncomparableJoin) Error() string {
+	return "uncomparable join"
+}
+
+func (e uncomparableJoin) Unwrap() []error {
+	return e
+}
+
+func TestCyclicChains(t *testing.T) {
+	cyclic := &cyclicError{}
+	cyclic.next = &cyclicError{next: cyclic}
+	err := &Error{Err: cyclic, frames: []StackFrame{}}
+
+	if Cause(err) != cyclic.next {
+		t.Errorf("Wrong cause: %#v", Cause(err))
+	}
+	if types := Types(err); !reflect.DeepEqual(types, []string{"*errors.Error", "*errors.cyclicError"}) {
+		t.Errorf("Wrong types: %v", types)
+	}
+	if CountErrors(err) != 1 || IsMessage(err, "missing") || UnwrapDeep(err) != cyclic {
+		t.Errorf("Wrong chain")
+	}
+	if innerError(err) != nil || deepestError(cyclic) != nil {
+		t.Errorf("Found an *Error in a cycle without one")
+	}
+	if !strings.HasPrefix(ChainStack(err), "*errors.cyclicError cyclic\n") {
+		t.Errorf("Wrong chain stack: %s", ChainStack(err))
+	}
+
+	joined := &cyclicJoin{}
+	joined.errs = []error{io.EOF, fmt.Errorf("wrapped: %w", joined), err}
+	if message := FlatMessage(joined); message != "EOF; cyclic" {
+		t.Errorf("Wrong message: %q", message)
+	}
+	if CountErrors(joined) != 1 || Cause(joined) != joined {
+		t.Errorf("Wrong cyclic join chain")
+	}
+
+	uncomparable := &Error{Err: uncomparableError{}, frames: []StackFrame{}}
+	if CountErrors(uncomparable) != 1 || IsMessage(uncomparable, "missing") || FlatMessage(uncomparable) != "uncomparable" {
+		t.Errorf("Wrong uncomparable chain")
+	}
+	if _, ok := Cause(uncomparable).(uncomparableError); !ok || joinIn(uncomparable) != nil {
+		t.Errorf("Wrong uncomparable cause: %#v", Cause(uncomparable))
+	}
+	if innerError(uncomparable) != nil || deepestError(uncomparable) != uncomparable {
+		t.Errorf("Wrong *Error found in uncomparable chain")
+	}
+	if tree := uncomparable.TreeString(); !strings.HasSuffix(tree, "(truncated)\n") {
+		t.Errorf("Wrong uncomparable tree:\n%s", tree)
+	}
+
+	multi := make(uncomparableJoin, 2)
+	multi[0], multi[1] = multi, multi
+	if CountErrors(multi) != 0 || len(Types(multi)) != 1 || IsMessage(multi, "missing") || FlatMessage(multi) != "" {
+		t.Errorf("Wrong uncomparable join chain")
+	}
+	if tree := (&Error{Err: multi, frames: []StackFrame{}}).TreeString(); strings.Count(tree, "\n") > maxChainErrors+1 {
+		t.Errorf("Uncomparable join tree not bounded: %d lines", strings.Count(tree, "\n"))
+	}
+}
+
+func TestIfType(t *testing.T) {
+	inner := New(io.EOF).(*Error)
+	err := fmt.Errorf("wrapped: %w", inner)
+
+	var found *Error
+	if !IfType(err, func(e *Error) { found = e }) || found != inner {
+		t.Errorf("*Error not found: %v", found)
+	}
+
+	called := false
+	if IfType(err, func(e *cyclicError) { called = true }) || called {
+		t.Errorf("Callback called for missing type")
+	}
+}
+
+func TestAsAll(t *testing.T) {
+	a := New("a").(*Error)
+	b := New("b").(*Error)
+	c := New("c").(*Error)
+	err := Wrap(errors.Join(a, fmt.Errorf("wrapped: %w", b), io.EOF, errors.Join(c)), 0).(*Error)
+
+	if matches := AsAll[*Error](err); !reflect.DeepEqual(matches, []*Error{err, a, b, c}) {
+		t.Errorf("Wrong matches: %v", matches)
+	}
+	if matches := AsAll[*cyclicError](err); matches != nil {
+		t.Errorf("Wrong matches: %v", matches)
+	}
+}
+
+type anyEOF struct{}
+
+func (anyEOF) Error() string {
+	return "any EOF"
+}
+
+func (anyEOF) Is(target error) bool {
+	return target == io.EOF
+}
+
+func TestExplain(t *testing.T) {
+	err := Wrap(fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), 0)
+
+	if WouldMatch(err, io.EOF) || !WouldMatch(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Wrong matches")
+	}
+
+	expected := "match: *errors.errorString \"unexpected EOF\" is target"
+	if explanation := Explain(err, io.ErrUnexpectedEOF); explanation != expected {
+		t.Errorf("Wrong explanation: %s", explanation)
+	}
+
+	expected = "no match:\n" +
+		"\t*errors.Error \"reading: unexpected EOF\": type differs from target type *errors.errorString\n" +
+		"\t*fmt.wrapError \"reading: unexpected EOF\": type differs from target type *errors.errorString\n" +
+		"\t*errors.errorString \"unexpected EOF\": different value of the same type\n"
+	if explanation := Explain(err, io.EOF); explanation != expected {
+		t.Errorf("Wrong explanation: %s", explanation)
+	}
+
+	if explanation := Explain(anyEOF{}, io.EOF); explanation != "match: errors.anyEOF \"any EOF\" reports Is(target)" {
+		t.Errorf("Wrong explanation: %s", explanation)
+	}
+	if explanation := Explain(anyEOF{}, io.ErrClosedPipe); !strings.HasSuffix(explanation, ", and Is(target) is false\n") {
+		t.Errorf("Wrong explanation: %s", explanation)
+	}
+	if explanation := Explain(nil, io.EOF); explanation != "no match: err is nil" {
+		t.Errorf("Wrong explanation: %s", explanation)
+	}
+}
+
+func TestCommonAncestor(t *testing.T) {
+	root := fmt.Errorf("root")
+	a := Wrap(fmt.Errorf("reading: %w", root), 0)
+	b := errors.Join(io.ErrClosedPipe, New(fmt.Errorf("parsing: %w", root)))
+
+	if ancestor := CommonAncestor(a, b); ancestor != root {
+		t.Errorf("Wrong ancestor: %v", ancestor)
+	}
+	if ancestor := CommonAncestor(a, a); ancestor != root {
+		t.Errorf("Wrong ancestor of itself: %v", ancestor)
+	}
+	if ancestor := CommonAncestor(fmt.Errorf("wrapped: %w", anyEOF{}), io.EOF); ancestor != (anyEOF{}) {
+		t.Errorf("Ancestor matched by Is not found: %v", ancestor)
+	}
+	if ancestor := CommonAncestor(a, io.ErrClosedPipe); ancestor != nil {
+		t.Errorf("Unrelated errors have ancestor: %v", ancestor)
+	}
+	if CommonAncestor(nil, a) != nil || CommonAncestor(a, nil) != nil {
+		t.Errorf("nil has ancestor")
+	}
+
+	cyclic := &cyclicError{}
+	cyclic.next = cyclic
+	if ancestor := CommonAncestor(fmt.Errorf("a: %w", cyclic), fmt.Errorf("b: %w", cyclic)); ancestor != cyclic {
+		t.Errorf("Wrong ancestor in cycle: %v", ancestor)
+	}
+}
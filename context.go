@@ -0,0 +1,30 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WrapContextErr returns ctx.Err() wrapped with a stacktrace, or nil if ctx
+// has no error. If ctx has a deadline the error is prefixed with how long ago
+// the deadline passed, or how long remains before it, measured when
+// WrapContextErr is called rather than when ctx was canceled. The skip
+// parameter indicates how far up the stack to start the stacktrace. 0 is from
+// the current call, 1 from its caller, etc.
+func WrapContextErr(ctx context.Context, skip int) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+
+	e := newSkip(err, skip)
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining > 0 {
+			e.prefix = fmt.Sprintf("canceled %s before deadline", remaining.Round(time.Millisecond))
+		} else {
+			e.prefix = fmt.Sprintf("deadline passed %s ago", (-remaining).Round(time.Millisecond))
+		}
+	}
+	return e
+}
@@ -0,0 +1,40 @@
+package errors
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrapContextErr(t *testing.T) {
+	if WrapContextErr(context.Background(), 0) != nil {
+		t.Errorf("Context without error wrapped")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err, expected := WrapContextErr(ctx, 0), callers()
+	if err.Error() != "context canceled" || !errors.Is(err, context.Canceled) {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if err := compareStacks(err.(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	cancel()
+	err = WrapContextErr(ctx, 0)
+	if !strings.HasPrefix(err.Error(), "canceled ") || !strings.HasSuffix(err.Error(), " before deadline: context canceled") {
+		t.Errorf("Wrong canceled message: %v", err)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err = WrapContextErr(ctx, 0)
+	if !strings.HasPrefix(err.Error(), "deadline passed ") || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wrong deadline message: %v", err)
+	}
+}
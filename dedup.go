@@ -0,0 +1,76 @@
+package errors
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A Deduper limits stack capture for errors that are created repeatedly. The
+// first error created for a key within Window gets a stacktrace, later ones
+// within the same Window do not. This prevents hot error paths from flooding
+// logs with identical stacks.
+type Deduper struct {
+	// Window is how long after a key is seen that it is considered a
+	// duplicate.
+	Window time.Duration
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// DefaultDeduper is the Deduper used by NewOnce.
+var DefaultDeduper = &Deduper{Window: time.Minute}
+
+// NewOnce makes an Error from the given value using DefaultDeduper. See
+// Deduper.New.
+func NewOnce(key string, e interface{}) error {
+	return DefaultDeduper.newOnce(key, e)
+}
+
+// New makes an Error from the given value in the same way as New, if key has
+// not been seen within the Window. Otherwise it returns a plain error without
+// a stacktrace: the value itself if it is an error, or fmt.Errorf("%v")
+// applied to it.
+func (d *Deduper) New(key string, e interface{}) error {
+	return d.newOnce(key, e)
+}
+
+func (d *Deduper) newOnce(key string, e interface{}) error {
+	if d.duplicate(key, time.Now()) {
+		if err, ok := e.(error); ok {
+			return err
+		}
+		return fmt.Errorf("%v", e)
+	}
+
+	return newSkip(e, 1)
+}
+
+// duplicate records key as seen at now, reporting whether it had already been
+// seen within the Window. Expired keys are pruned at most once per Window, so
+// a storm of distinct keys does not scan them all on every call.
+func (d *Deduper) duplicate(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if last, ok := d.seen[key]; ok && now.Sub(last) < d.Window {
+		return true
+	}
+
+	if d.seen == nil {
+		d.seen = map[string]time.Time{}
+	}
+	if now.Sub(d.pruned) >= d.Window {
+		for k, last := range d.seen {
+			if now.Sub(last) >= d.Window {
+				delete(d.seen, k)
+			}
+		}
+		d.pruned = now
+	}
+	d.seen[key] = now
+
+	return false
+}
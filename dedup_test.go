@@ -0,0 +1,63 @@
+package errors
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	d := &Deduper{Window: time.Hour}
+
+	first, expected := d.New("key", io.EOF), callers()
+	if err := compareStacks(first.(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	if second := d.New("key", io.EOF); second != io.EOF {
+		t.Errorf("Duplicate was not returned without a stack: %#v", second)
+	}
+
+	if second := d.New("key", "foo"); second.Error() != "foo" {
+		t.Errorf("Wrong duplicate message: %s", second.Error())
+	}
+
+	if other, ok := d.New("other", io.EOF).(*Error); !ok || other.Err != io.EOF {
+		t.Errorf("Different key was deduplicated")
+	}
+
+	d.Window = 0
+	if _, ok := d.New("key", io.EOF).(*Error); !ok {
+		t.Errorf("Key was deduplicated outside of window")
+	}
+}
+
+func TestNewOnce(t *testing.T) {
+	defer func(d *Deduper) { DefaultDeduper = d }(DefaultDeduper)
+	DefaultDeduper = &Deduper{Window: time.Minute}
+
+	if _, ok := NewOnce("TestNewOnce", io.EOF).(*Error); !ok {
+		t.Errorf("First error has no stack")
+	}
+	if _, ok := NewOnce("TestNewOnce", io.EOF).(*Error); ok {
+		t.Errorf("Duplicate error has a stack")
+	}
+}
+
+func TestDeduperPrune(t *testing.T) {
+	d := &Deduper{Window: time.Minute}
+	start := time.Now()
+
+	d.duplicate("a", start)
+	d.duplicate("b", start.Add(30*time.Second))
+	d.duplicate("c", start.Add(50*time.Second))
+	if len(d.seen) != 3 {
+		t.Errorf("Pruned within a Window of the last prune: %v", d.seen)
+	}
+
+	d.duplicate("d", start.Add(80*time.Second))
+	if _, ok := d.seen["a"]; ok || len(d.seen) != 3 {
+		t.Errorf("Expired keys not pruned: %v", d.seen)
+	}
+}
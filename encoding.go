@@ -0,0 +1,428 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// decodedError stands in for the original error of an *Error that has been
+// restored by UnmarshalBinary. The concrete type of the original error cannot
+// be recovered, so its message and type name are kept instead.
+type decodedError struct {
+	message  string
+	typeName string
+}
+
+func (e decodedError) Error() string {
+	return e.message
+}
+
+// decodedJoin stands in for an original error that was a Join, keeping the
+// joined errors so that ErrorStack shows each of them.
+type decodedJoin struct {
+	decodedError
+	errs []error
+}
+
+func (e decodedJoin) Unwrap() []error {
+	return e.errs
+}
+
+// binaryError is the wire representation used by MarshalBinary.
+type binaryError struct {
+	Message  string
+	Prefix   string
+	TypeName string
+	Frames   []StackFrame
+	MaxDepth int
+	Metadata map[string]interface{}
+	Created  time.Time
+	Labeled  []binaryLabeledStack
+	Revision string
+	Joined   [][]byte
+	Creator  string
+	Text     string
+}
+
+// binaryLabeledStack is the wire representation of a stack added by AddStack.
+type binaryLabeledStack struct {
+	Label  string
+	Frames []StackFrame
+	At     time.Time
+}
+
+func init() {
+	// Metadata values are stored as interfaces, so their concrete types must
+	// be registered to be encoded by MarshalBinary.
+	gob.Register(Env{})
+	gob.Register(Request{})
+	gob.Register(Severity(0))
+	gob.Register(SQLQuery{})
+	gob.Register(map[string]interface{}{})
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The message, prefix,
+// type name, resolved stack frames, metadata and, if the wrapped error is a
+// Join, each joined error are encoded so that Error() and ErrorStack() are
+// preserved when the result is passed to UnmarshalBinary.
+// Values attached with WithSnapshot must be of types registered with
+// gob.Register, or encoding fails.
+func (err *Error) MarshalBinary() ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	b := binaryError{
+		Message:  err.Err.Error(),
+		Prefix:   err.prefix,
+		TypeName: err.TypeName(),
+		Frames:   err.StackFrames(),
+		MaxDepth: err.maxDepth,
+		Metadata: err.metadata,
+		Created:  err.created,
+		Revision: err.revision,
+		Creator:  err.creator,
+		Text:     err.text,
+	}
+	for i := range err.labeled {
+		b.Labeled = append(b.Labeled, binaryLabeledStack{
+			Label:  err.labeled[i].label,
+			Frames: err.labeled[i].StackFrames(),
+			At:     err.labeled[i].at,
+		})
+	}
+	if joined, ok := err.Err.(interface{ Unwrap() []error }); ok {
+		for _, joinedErr := range joined.Unwrap() {
+			child, ok := joinedErr.(*Error)
+			if !ok {
+				child = &Error{Err: joinedErr, frames: []StackFrame{}}
+			}
+			data, e := child.MarshalBinary()
+			if e != nil {
+				return nil, e
+			}
+			b.Joined = append(b.Joined, data)
+		}
+	}
+	if e := gob.NewEncoder(&buf).Encode(b); e != nil {
+		return nil, e
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, restoring an *Error
+// encoded by MarshalBinary. The wrapped error is replaced by one carrying the
+// original message and type name, and, if it was a Join, the joined errors,
+// each restored as an *Error.
+func (err *Error) UnmarshalBinary(data []byte) error {
+	var b binaryError
+	if e := gob.NewDecoder(bytes.NewReader(data)).Decode(&b); e != nil {
+		return e
+	}
+
+	frames := b.Frames
+	if frames == nil {
+		frames = []StackFrame{}
+	}
+
+	*err = Error{
+		Err:      decodedError{message: b.Message, typeName: b.TypeName},
+		frames:   frames,
+		prefix:   b.Prefix,
+		maxDepth: b.MaxDepth,
+		metadata: b.Metadata,
+		created:  b.Created,
+		revision: b.Revision,
+		creator:  b.Creator,
+		text:     b.Text,
+	}
+	for _, l := range b.Labeled {
+		frames := l.Frames
+		if frames == nil {
+			frames = []StackFrame{}
+		}
+		err.labeled = append(err.labeled, labeledStack{label: l.Label, frames: frames, at: l.At})
+	}
+	if b.Joined != nil {
+		joined := decodedJoin{decodedError: err.Err.(decodedError)}
+		for _, data := range b.Joined {
+			child := &Error{}
+			if e := child.UnmarshalBinary(data); e != nil {
+				return e
+			}
+			joined.errs = append(joined.errs, child)
+		}
+		err.Err = joined
+	}
+
+	return nil
+}
+
+// JSONStackDepth is the maximum number of frames, from the top of the stack,
+// included by MarshalJSON. If the stack is deeper it is marked as truncated.
+// Zero, the default, means no limit.
+var JSONStackDepth = 0
+
+// FrameInfo is the representation of a StackFrame used by MarshalJSON and
+// CrashReport.
+type FrameInfo struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
+	Package  string `json:"package"`
+}
+
+// jsonError is the representation of an *Error used by MarshalJSON.
+type jsonError struct {
+	Type      string                 `json:"type"`
+	Message   string                 `json:"message"`
+	Stack     []FrameInfo            `json:"stack"`
+	Truncated bool                   `json:"truncated,omitempty"`
+	Time      *time.Time             `json:"time,omitempty"`
+	Revision  string                 `json:"revision,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. The error is encoded as an object
+// with its type name, message, resolved stack frames, creation time and
+// revision (if captured) and any metadata. At most JSONStackDepth frames are included.
+func (err *Error) MarshalJSON() ([]byte, error) {
+	frames := err.StackFrames()
+	truncated := JSONStackDepth > 0 && len(frames) > JSONStackDepth
+	if truncated {
+		frames = frames[:JSONStackDepth]
+	}
+
+	j := jsonError{
+		Type:      err.TypeName(),
+		Message:   err.Error(),
+		Stack:     frameInfos(frames),
+		Truncated: truncated,
+		Revision:  err.revision,
+		Metadata:  err.metadata,
+	}
+	if !err.created.IsZero() {
+		j.Time = &err.created
+	}
+
+	return json.Marshal(j)
+}
+
+// frameInfos converts frames to FrameInfos.
+func frameInfos(frames []StackFrame) []FrameInfo {
+	infos := make([]FrameInfo, len(frames))
+	for i, frame := range frames {
+		infos[i] = FrameInfo{
+			File:     frame.displayFile(),
+			Line:     frame.LineNumber,
+			Function: frame.Name,
+			Package:  redact(frame.Package),
+		}
+	}
+	return infos
+}
+
+// CrashReport bundles everything a crash reporting backend needs about an
+// error, ready to be marshaled and uploaded. Optional fields are nil or empty
+// when not present.
+type CrashReport struct {
+	Message     string                 `json:"message"`
+	Type        string                 `json:"type"`
+	Stack       []FrameInfo            `json:"stack"`
+	Timestamp   *time.Time             `json:"timestamp,omitempty"`
+	Revision    string                 `json:"revision,omitempty"`
+	Code        *int                   `json:"code,omitempty"`
+	UserMessage string                 `json:"user_message,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// CrashReport returns a CrashReport for the error. Timestamp is set if
+// CaptureTimestamps was and Revision if CaptureRevision was. Code and
+// UserMessage are those found in the chain by CodeOf and UserMessage, and
+// Metadata holds a copy of every annotation on the error, such as the request
+// recorded by WithRequest.
+func (err *Error) CrashReport() CrashReport {
+	report := CrashReport{
+		Message:  err.Error(),
+		Type:     err.TypeName(),
+		Stack:    frameInfos(err.StackFrames()),
+		Revision: err.revision,
+	}
+	if !err.created.IsZero() {
+		created := err.created
+		report.Timestamp = &created
+	}
+	if code, ok := CodeOf(err); ok {
+		report.Code = &code
+	}
+	report.UserMessage, _ = UserMessage(err)
+	if len(err.metadata) > 0 {
+		report.Metadata = err.Metadata()
+	}
+	return report
+}
+
+// StackFields returns the resolved stack frames as plain data, one map per
+// frame with "file", "line", "func" and "package" keys. It is suitable as a
+// structured field value for loggers such as logrus.
+func (err *Error) StackFields() []map[string]interface{} {
+	frames := err.StackFrames()
+	fields := make([]map[string]interface{}, len(frames))
+	for i, frame := range frames {
+		fields[i] = map[string]interface{}{
+			"file":    frame.displayFile(),
+			"line":    frame.LineNumber,
+			"func":    frame.Name,
+			"package": redact(frame.Package),
+		}
+	}
+	return fields
+}
+
+// OTelAttributes returns the OpenTelemetry semantic convention attributes for
+// recording the error as an exception span event: exception.type,
+// exception.message and exception.stacktrace. A plain map is returned so that
+// this package does not depend on OpenTelemetry.
+func (err *Error) OTelAttributes() map[string]string {
+	return map[string]string{
+		"exception.type":       err.TypeName(),
+		"exception.message":    err.Error(),
+		"exception.stacktrace": string(err.Stack()),
+	}
+}
+
+// grpcUnknown is the gRPC status code Unknown, used when no code is attached.
+const grpcUnknown = 2
+
+// grpcCodes maps HTTP status codes to the gRPC status codes with the same
+// meaning, as documented for google.rpc.Code.
+var grpcCodes = map[int]int{
+	400: 3,  // InvalidArgument
+	401: 16, // Unauthenticated
+	403: 7,  // PermissionDenied
+	404: 5,  // NotFound
+	409: 10, // Aborted
+	429: 8,  // ResourceExhausted
+	499: 1,  // Canceled
+	500: 13, // Internal
+	501: 12, // Unimplemented
+	503: 14, // Unavailable
+	504: 4,  // DeadlineExceeded
+}
+
+// ToStatusDetails returns the error as plain data suitable for building a gRPC
+// status: "code" is the gRPC code corresponding to the HTTP status code
+// attached by WrapCode (or 2, Unknown, if there is none or it has no
+// equivalent), "message" is Error(), and "stack" lists each frame as a
+// "package.Function file:line" string. Plain data is returned so that this
+// package does not depend on gRPC.
+func (err *Error) ToStatusDetails() map[string]interface{} {
+	code := grpcUnknown
+	if status, ok := CodeOf(err); ok {
+		if c, ok := grpcCodes[status]; ok {
+			code = c
+		}
+	}
+
+	frames := err.StackFrames()
+	stack := make([]string, len(frames))
+	for i, frame := range frames {
+		stack[i] = fmt.Sprintf("%s.%s %s:%d", redact(frame.Package), frame.Name, frame.displayFile(), frame.LineNumber)
+	}
+
+	return map[string]interface{}{
+		"code":    code,
+		"message": err.Error(),
+		"stack":   stack,
+	}
+}
+
+// OmitProblemDetail leaves "detail" out of the result of ToProblemDetail, so
+// that the error's own message, which may describe internal details, is not
+// sent to clients. The default is false.
+var OmitProblemDetail = false
+
+// ToProblemDetail returns the error as an RFC 7807 problem details object,
+// suitable for marshaling as an application/problem+json response body.
+// "status" is the code attached by WrapCode if it is an HTTP status code, or
+// 500 otherwise, and "type" is always "about:blank". "title" is the message
+// attached by WithUserMessage, or the standard text of the status if there is
+// none, e.g. "Not Found". "detail" is BaseMessage, unless OmitProblemDetail is
+// set. The stack is not included, as the result is intended for clients.
+func (err *Error) ToProblemDetail() map[string]interface{} {
+	status, ok := CodeOf(err)
+	if !ok || status < 100 || status > 599 || http.StatusText(status) == "" {
+		status = 500
+	}
+
+	title, ok := UserMessage(err)
+	if !ok {
+		title = http.StatusText(status)
+	}
+
+	detail := map[string]interface{}{
+		"type":   "about:blank",
+		"title":  title,
+		"status": status,
+	}
+	if !OmitProblemDetail {
+		detail["detail"] = err.BaseMessage()
+	}
+	return detail
+}
+
+// ProtoError is a flat representation of an *Error, made only of scalars and
+// repeated messages, that maps directly to a protobuf message such as:
+//
+//	message Error {
+//	  string message = 1;
+//	  string type = 2;
+//	  int32 code = 3;
+//	  repeated Frame frames = 4;
+//	}
+//
+//	message Frame {
+//	  string file = 1;
+//	  int32 line = 2;
+//	  string function = 3;
+//	}
+type ProtoError struct {
+	Message string
+	Type    string
+	Code    int32
+	Frames  []ProtoFrame
+}
+
+// ProtoFrame is a stack frame of a ProtoError.
+type ProtoFrame struct {
+	File     string
+	Line     int32
+	Function string
+}
+
+// ToProto returns the error as a ProtoError, to be copied into a generated
+// protobuf message. Code is the code attached by WrapCode, or 0 if there is
+// none. Functions are qualified by their package. This package does not depend
+// on protobuf.
+func (err *Error) ToProto() ProtoError {
+	code, _ := CodeOf(err)
+
+	frames := err.StackFrames()
+	p := ProtoError{
+		Message: err.Error(),
+		Type:    err.TypeName(),
+		Code:    int32(code),
+		Frames:  make([]ProtoFrame, len(frames)),
+	}
+	for i, frame := range frames {
+		p.Frames[i] = ProtoFrame{
+			File:     frame.displayFile(),
+			Line:     int32(frame.LineNumber),
+			Function: redact(frame.Package) + "." + frame.Name,
+		}
+	}
+	return p
+}
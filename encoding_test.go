@@ -0,0 +1,371 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	original := WrapPrefix(errorString("boom"), "prefix", 0).(*Error)
+	original.creator = "main.startWorkers"
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Error
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Error() != original.Error() {
+		t.Errorf("Wrong message: %s", decoded.Error())
+	}
+
+	if decoded.TypeName() != "errors.errorString" {
+		t.Errorf("Wrong type: %s", decoded.TypeName())
+	}
+
+	if !reflect.DeepEqual(decoded.StackFrames(), original.StackFrames()) {
+		t.Errorf("Wrong stack: %#v", decoded.StackFrames())
+	}
+
+	if decoded.ErrorStack() != original.ErrorStack() {
+		t.Errorf("ErrorStack not preserved:\n%s\n%s", decoded.ErrorStack(), original.ErrorStack())
+	}
+
+	if decoded.CreatedBy() != "main.startWorkers" {
+		t.Errorf("Creator not preserved: %s", decoded.CreatedBy())
+	}
+}
+
+func TestMarshalBinaryPanic(t *testing.T) {
+	original, err := ParsePanic(createdBy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Error
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.TypeName() != "panic" || decoded.ErrorStack() != original.ErrorStack() {
+		t.Errorf("Panic not preserved: %s", decoded.ErrorStack())
+	}
+}
+
+func TestMarshalBinaryTruncated(t *testing.T) {
+	original := &Error{Err: errorString("foo"), frames: []StackFrame{{Name: "a"}, {Name: "b"}}, maxDepth: 2}
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Error
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if !decoded.Truncated() || decoded.ErrorStack() != original.ErrorStack() {
+		t.Errorf("Truncation not preserved: %s", decoded.ErrorStack())
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	original := &Error{Err: errorString("foo"), prefix: "prefix", frames: []StackFrame{
+		{File: "/src/app/main.go", LineNumber: 12, Package: "main", Name: "main"},
+	}}
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"type":"errors.errorString","message":"prefix: foo","stack":[{"file":"/src/app/main.go","line":12,"function":"main","package":"main"}]}`
+	if string(data) != expected {
+		t.Errorf("Wrong JSON: %s", data)
+	}
+}
+
+func TestMarshalBinaryMetadata(t *testing.T) {
+	original := NewWithEnv("foo").(*Error)
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Error
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.ErrorStack() != original.ErrorStack() {
+		t.Errorf("Metadata not preserved:\n%s", decoded.ErrorStack())
+	}
+}
+
+func TestOTelAttributes(t *testing.T) {
+	err := New(errorString("foo")).(*Error)
+
+	expected := map[string]string{
+		"exception.type":       "errors.errorString",
+		"exception.message":    "foo",
+		"exception.stacktrace": string(err.Stack()),
+	}
+	if attrs := err.OTelAttributes(); !reflect.DeepEqual(attrs, expected) {
+		t.Errorf("Wrong attributes: %v", attrs)
+	}
+}
+
+func TestToStatusDetails(t *testing.T) {
+	frames := []StackFrame{{File: "/src/app/main.go", LineNumber: 12, Package: "main", Name: "main"}}
+
+	err := &Error{Err: errorString("foo"), frames: frames}
+	expected := map[string]interface{}{
+		"code":    2,
+		"message": "foo",
+		"stack":   []string{"main.main /src/app/main.go:12"},
+	}
+	if details := err.ToStatusDetails(); !reflect.DeepEqual(details, expected) {
+		t.Errorf("Wrong details: %v", details)
+	}
+
+	coded := WrapCode(err, 404, "not found").(*Error)
+	if details := coded.ToStatusDetails(); details["code"] != 5 || details["message"] != "not found: foo" {
+		t.Errorf("Wrong coded details: %v", details)
+	}
+
+	if details := WrapCode(err, 418, "").(*Error).ToStatusDetails(); details["code"] != 2 {
+		t.Errorf("Code without gRPC equivalent not Unknown: %v", details)
+	}
+}
+
+func TestMarshalBinaryLabeledStacks(t *testing.T) {
+	original := New("foo").(*Error).AddStack("enqueued")
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Error
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.ErrorStack() != original.ErrorStack() {
+		t.Errorf("Labeled stacks not preserved:\n%s", decoded.ErrorStack())
+	}
+}
+
+func TestJSONStackDepth(t *testing.T) {
+	defer func(depth int) { JSONStackDepth = depth }(JSONStackDepth)
+
+	original := &Error{Err: errorString("foo"), frames: []StackFrame{
+		{File: "a.go", LineNumber: 1, Package: "main", Name: "a"},
+		{File: "b.go", LineNumber: 2, Package: "main", Name: "b"},
+	}}
+
+	JSONStackDepth = 1
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"errors.errorString","message":"foo","stack":[{"file":"a.go","line":1,"function":"a","package":"main"}],"truncated":true}`
+	if string(data) != expected {
+		t.Errorf("Wrong JSON: %s", data)
+	}
+
+	JSONStackDepth = 2
+	if data, _ := json.Marshal(original); strings.Contains(string(data), "truncated") {
+		t.Errorf("Stack marked as truncated: %s", data)
+	}
+}
+
+func TestStackFields(t *testing.T) {
+	err := &Error{Err: errorString("foo"), frames: []StackFrame{
+		{File: "/src/app/main.go", LineNumber: 12, Package: "main", Name: "main"},
+	}}
+
+	expected := []map[string]interface{}{
+		{"file": "/src/app/main.go", "line": 12, "func": "main", "package": "main"},
+	}
+	if fields := err.StackFields(); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Wrong fields: %v", fields)
+	}
+}
+
+func TestToProblemDetail(t *testing.T) {
+	defer func(omit bool) { OmitProblemDetail = omit }(OmitProblemDetail)
+
+	err := New(errorString("foo")).(*Error)
+	expected := map[string]interface{}{
+		"type":   "about:blank",
+		"title":  "Internal Server Error",
+		"detail": "foo",
+		"status": 500,
+	}
+	if detail := err.ToProblemDetail(); !reflect.DeepEqual(detail, expected) {
+		t.Errorf("Wrong problem detail: %v", detail)
+	}
+
+	coded := WrapCode(err, 404, "not found").(*Error).WithUserMessage("No such user")
+	expected = map[string]interface{}{
+		"type":   "about:blank",
+		"title":  "No such user",
+		"detail": "foo",
+		"status": 404,
+	}
+	if detail := coded.ToProblemDetail(); !reflect.DeepEqual(detail, expected) {
+		t.Errorf("Wrong coded problem detail: %v", detail)
+	}
+
+	if detail := WrapCode(err, 404, "").(*Error).ToProblemDetail(); detail["title"] != "Not Found" {
+		t.Errorf("Status text not used as title: %v", detail)
+	}
+	if detail := WrapCode(err, 5, "").(*Error).ToProblemDetail(); detail["status"] != 500 {
+		t.Errorf("Non HTTP code used as status: %v", detail)
+	}
+
+	OmitProblemDetail = true
+	if detail, ok := coded.ToProblemDetail()["detail"]; ok {
+		t.Errorf("Detail not omitted: %v", detail)
+	}
+}
+
+func TestCrashReport(t *testing.T) {
+	err := &Error{Err: errorString("foo"), frames: []StackFrame{
+		{File: "/src/app/main.go", LineNumber: 12, Package: "main", Name: "main"},
+	}}
+
+	expected := CrashReport{
+		Message: "foo",
+		Type:    "errors.errorString",
+		Stack:   []FrameInfo{{File: "/src/app/main.go", Line: 12, Function: "main", Package: "main"}},
+	}
+	if report := err.CrashReport(); !reflect.DeepEqual(report, expected) {
+		t.Errorf("Wrong report: %#v", report)
+	}
+
+	coded := WrapCode(err, 404, "not found").(*Error).WithUserMessage("No such user").WithRequest("GET", "/users/1", 404)
+	report := coded.CrashReport()
+	if report.Code == nil || *report.Code != 404 || report.UserMessage != "No such user" || report.Timestamp != nil {
+		t.Errorf("Wrong optional fields: %#v", report)
+	}
+	if _, ok := report.Metadata[requestKey].(Request); !ok {
+		t.Errorf("Metadata missing: %#v", report.Metadata)
+	}
+
+	data, e := json.Marshal(err.CrashReport())
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(data) != `{"message":"foo","type":"errors.errorString","stack":[{"file":"/src/app/main.go","line":12,"function":"main","package":"main"}]}` {
+		t.Errorf("Wrong JSON: %s", data)
+	}
+}
+
+func TestToProto(t *testing.T) {
+	err := &Error{Err: errorString("foo"), frames: []StackFrame{
+		{File: "/src/app/main.go", LineNumber: 12, Package: "main", Name: "main"},
+	}}
+
+	expected := ProtoError{
+		Message: "foo",
+		Type:    "errors.errorString",
+		Frames:  []ProtoFrame{{File: "/src/app/main.go", Line: 12, Function: "main.main"}},
+	}
+	if p := err.ToProto(); !reflect.DeepEqual(p, expected) {
+		t.Errorf("Wrong proto: %#v", p)
+	}
+
+	if p := WrapCode(err, 5, "not found").(*Error).ToProto(); p.Code != 5 || p.Message != "not found: foo" {
+		t.Errorf("Wrong coded proto: %#v", p)
+	}
+}
+
+func TestRevision(t *testing.T) {
+	defer func(capture bool) { CaptureRevision = capture }(CaptureRevision)
+
+	if err := New("foo").(*Error); err.Revision() != "" {
+		t.Errorf("Revision captured by default: %s", err.Revision())
+	}
+
+	// test binaries are not stamped with a revision, so fake one
+	CaptureRevision = true
+	revision()
+	defer func(revision string) { buildRevision.revision = revision }(buildRevision.revision)
+	buildRevision.revision = "0123abcd"
+
+	err := New("foo").(*Error)
+	if err.Revision() != "0123abcd" {
+		t.Errorf("Wrong revision: %s", err.Revision())
+	}
+	if data, _ := json.Marshal(err); !strings.Contains(string(data), `"revision":"0123abcd"`) {
+		t.Errorf("Revision not in JSON: %s", data)
+	}
+	if report := err.CrashReport(); report.Revision != "0123abcd" {
+		t.Errorf("Revision not in report: %#v", report)
+	}
+
+	data, e := err.MarshalBinary()
+	if e != nil {
+		t.Fatal(e)
+	}
+	var decoded Error
+	if e := decoded.UnmarshalBinary(data); e != nil {
+		t.Fatal(e)
+	}
+	if decoded.Revision() != "0123abcd" {
+		t.Errorf("Revision not preserved: %s", decoded.Revision())
+	}
+}
+
+func TestMarshalBinaryJoined(t *testing.T) {
+	original := JoinWithStack(New(errorString("foo")), io.EOF, errors.Join(New(io.ErrClosedPipe), io.ErrUnexpectedEOF)).(*Error)
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Error
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.ErrorStack() != original.ErrorStack() {
+		t.Errorf("Joined errors not preserved:\n%s\n%s", decoded.ErrorStack(), original.ErrorStack())
+	}
+}
+
+func TestMarshalBinaryStackText(t *testing.T) {
+	original := NewFromStackText("worker stalled", "goroutine 7 [running]:\nmain.run()\n\t/src/app/main.go:42 +0x1d\n")
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Error
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.ErrorStack() != original.ErrorStack() {
+		t.Errorf("Stack text not preserved:\n%s", decoded.ErrorStack())
+	}
+}
@@ -47,21 +47,110 @@ package errors
 
 import (
 	"bytes"
+	baseErrors "errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// The maximum number of stackframes on any error.
+// The maximum number of stackframes on any error. Which frames are kept from
+// a deeper stack is chosen by StackTruncation.
 var MaxStackDepth = 50
 
+// Truncation selects which end of a stack deeper than MaxStackDepth is kept.
+type Truncation int
+
+const (
+	// KeepLeaf keeps the frames nearest to where the stack was captured,
+	// dropping the outermost callers.
+	KeepLeaf Truncation = iota
+	// KeepRoot keeps the outermost callers, dropping the frames nearest to
+	// where the stack was captured. This can be more useful for deep
+	// recursion, but requires capturing the whole stack.
+	KeepRoot
+)
+
+// StackTruncation is the end of a stack deeper than MaxStackDepth that is
+// kept. The default is KeepLeaf.
+var StackTruncation = KeepLeaf
+
+// PrefixSeparator separates a prefix from the error message in Error, and
+// nested prefixes from each other in WrapPrefix.
+var PrefixSeparator = ": "
+
+// CallerFirst makes Stack and ErrorStack print frames with the outermost caller
+// first. The default is false, printing the frame where the stack was captured
+// first, as go does.
+var CallerFirst = false
+
+// CaptureTimestamps records the time at which each Error is created, shown by
+// ChainStack as the time elapsed between layers of wrapping. The default is
+// false, avoiding the cost of reading the clock.
+var CaptureTimestamps = false
+
+// CaptureCreatedBy records, for each Error made by New or Wrap, the function
+// that started the goroutine in which it was made, shown by ErrorStack as
+// "created by pkg.Func". This identifies the spawning site of errors from
+// asynchronous work. The default is false, as the creator can only be found by
+// formatting the goroutine's stack with runtime.Stack.
+var CaptureCreatedBy = false
+
+// GroupByPackage makes Stack and ErrorStack print the package path once, as a
+// header above each run of frames from the same package, with each frame shown
+// as "file.go:42 Func" beneath it. This reduces the clutter of long import
+// paths in deep stacks. The default is false, printing every frame according
+// to StackFrameFormat.
+var GroupByPackage = false
+
+// AppendOriginToMessage makes Error return the message followed by the file
+// and line where the stack was captured, e.g. "user not found (order.go:42)",
+// giving a hint of where an error came from without ErrorStack. Only the top
+// frame is resolved. The default is false.
+var AppendOriginToMessage = false
+
+// CaptureRevision records, for each Error made by New or Wrap, the version
+// control revision the binary was built from, as reported by
+// runtime/debug.ReadBuildInfo, so that errors can be attributed to a deploy.
+// The build info is read once and cached. The default is false.
+var CaptureRevision = false
+
+// SampleRate is the fraction of errors made by New and Wrap that capture a
+// stacktrace. Below 1.0 a random selection of errors is made without a stack,
+// trading completeness for speed on very hot paths. Such errors are otherwise
+// valid, and report false from HasStack. The default is 1.0, always capturing.
+var SampleRate = 1.0
+
 // Error is an error with an attached stacktrace. It can be used
 // wherever the builtin error interface is expected.
 type Error struct {
-	Err    error
-	stack  []uintptr
-	frames []StackFrame
-	prefix string
+	Err      error
+	stack    []uintptr
+	frames   []StackFrame
+	prefix   string
+	maxDepth int
+	metadata map[string]interface{}
+	created  time.Time
+	labeled  []labeledStack
+	sites    []string
+	values   map[interface{}]interface{}
+	text     string
+	creator  string
+	gid      uint64
+	revision string
+	filter   FrameFilter
+
+	// frames after filtering, and the options used to filter them
+	filtered   []StackFrame
+	filteredBy filterState
 }
 
 // New makes an Error from the given value. If that value is already an
@@ -69,6 +158,19 @@ type Error struct {
 // fmt.Errorf("%v"). The stacktrace will point to the line of code that
 // called New.
 func New(e interface{}) error {
+	return newSkip(e, 0)
+}
+
+// NewSkip makes an Error from the given value in the same way as New. The skip
+// parameter indicates how far up the stack to start the stacktrace. 0 is from
+// the current call, 1 from its caller, etc. This allows helper functions to
+// attribute the error to their caller.
+func NewSkip(skip int, e interface{}) error {
+	return newSkip(e, skip)
+}
+
+// internal New returning *Error. This should never return nil
+func newSkip(e interface{}, skip int) *Error {
 	var err error
 
 	switch e := e.(type) {
@@ -78,11 +180,20 @@ func New(e interface{}) error {
 		err = fmt.Errorf("%v", e)
 	}
 
+	if !sampled() {
+		return &Error{Err: err, created: now()}
+	}
+
 	stack := make([]uintptr, MaxStackDepth)
-	length := runtime.Callers(2, stack[:])
+	length := captureCallers(3+skip, stack[:])
 	return &Error{
-		Err:   err,
-		stack: stack[:length],
+		Err:      err,
+		stack:    stack[:length],
+		maxDepth: len(stack),
+		created:  now(),
+		creator:  creator(),
+		gid:      goroutineID(),
+		revision: revision(),
 	}
 }
 
@@ -114,12 +225,70 @@ func wrap(e interface{}, skip int) *Error {
 		err = fmt.Errorf("%v", e)
 	}
 
+	if !sampled() {
+		return &Error{Err: err, created: now()}
+	}
+
 	stack := make([]uintptr, MaxStackDepth)
-	length := runtime.Callers(3+skip, stack[:])
+	length := captureCallers(3+skip, stack[:])
 	return &Error{
-		Err:   err,
-		stack: stack[:length],
+		Err:      err,
+		stack:    stack[:length],
+		maxDepth: len(stack),
+		created:  now(),
+		creator:  creator(),
+		gid:      goroutineID(),
+		revision: revision(),
+	}
+}
+
+// WrapKeepDeepest makes an Error from the given value in the same way as
+// Wrap, except that if the value wraps an *Error further down its chain, for
+// example through fmt.Errorf("%w"), the stacktrace of the deepest such *Error
+// is reused instead of capturing a new one. The stack of the first wrap, which
+// is the closest to where the error occurred, so survives being wrapped again
+// at higher layers. WrapPrefix keeps the stack of an *Error, so it may be
+// applied to the result to add a prefix. The skip parameter is used only when a
+// new stack is captured.
+func WrapKeepDeepest(e interface{}, skip int) error {
+	if e == nil {
+		return nil
+	}
+
+	if err, ok := e.(error); ok {
+		if _, ok := err.(*Error); !ok {
+			if deepest := deepestError(err); deepest != nil {
+				return &Error{
+					Err:      err,
+					stack:    deepest.stack,
+					frames:   deepest.frames,
+					maxDepth: deepest.maxDepth,
+					created:  now(),
+				}
+			}
+		}
 	}
+
+	return wrap(e, skip)
+}
+
+// deepestError returns the last *Error in err's chain, found by repeatedly
+// calling Unwrap() error, or nil if there is none.
+func deepestError(err error) *Error {
+	var deepest *Error
+	var seen []error
+	for err != nil && len(seen) < maxChainErrors && !seenBefore(err, seen) {
+		if e, ok := err.(*Error); ok {
+			deepest = e
+		}
+		next, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		seen = append(seen, err)
+		err = next.Unwrap()
+	}
+	return deepest
 }
 
 // WrapPrefix makes an Error from the given value. If that value is already an
@@ -127,54 +296,472 @@ func wrap(e interface{}, skip int) *Error {
 // modification. If that value is already an error then it will be used
 // directly and wrapped.  Otherwise, the value will be passed to
 // fmt.Errorf("%v") and then wrapped. To explicitly wrap an *Error with a new
-// stacktrace use Errorf. The prefix parameter is used to add a prefix to the
-// error message when calling Error(). The skip parameter indicates how far up
-// the stack to start the stacktrace. 0 is from the current call, 1 from its
-// caller, etc.
+// stacktrace use Errorf. The metadata of an *Error is kept, and the file and
+// line of the call are recorded, see WrapSites. The prefix parameter is used
+// to add a prefix to the error message when calling Error(). The skip
+// parameter indicates how far up the stack to start the stacktrace. 0 is from
+// the current call, 1 from its caller, etc.
 func WrapPrefix(e interface{}, prefix string, skip int) error {
 	if e == nil {
 		return nil
 	}
 
+	return wrapPrefix(e, prefix, 1+skip)
+}
+
+// WrapPrefixf is WrapPrefix with the prefix formatted from format and args as
+// by fmt.Sprintf.
+func WrapPrefixf(e interface{}, skip int, format string, args ...interface{}) error {
+	if e == nil {
+		return nil
+	}
+
+	return wrapPrefix(e, fmt.Sprintf(format, args...), 1+skip)
+}
+
+// internal WrapPrefix returning *Error. This should never return nil
+func wrapPrefix(e interface{}, prefix string, skip int) *Error {
 	err := wrap(e, skip)
+	_, file, line, _ := runtime.Caller(1 + skip)
 
 	if err.prefix != "" {
-		prefix = fmt.Sprintf("%s: %s", prefix, err.prefix)
+		prefix = prefix + PrefixSeparator + err.prefix
+	}
+
+	// copy rather than rebuild so that metadata is carried forward. The
+	// metadata map is shared, as it is copied before any modification.
+	prefixed := *err
+	prefixed.prefix = prefix
+	prefixed.sites = append(err.sites[:len(err.sites):len(err.sites)], fmt.Sprintf("%s:%d", filepath.Base(file), line))
+	return &prefixed
+}
+
+// WrapFiltered makes an Error from the given value in the same way as Wrap,
+// with filter applied to the frames returned by its StackFrames, after any
+// package level filtering. This lets a subsystem clean up its own stacks
+// without affecting others. The filter is stored, not applied when the stack
+// is captured, so Callers still returns every program counter. If the value
+// is already an *Error, a copy with the filter is returned.
+func WrapFiltered(e interface{}, skip int, filter FrameFilter) error {
+	if e == nil {
+		return nil
+	}
+
+	filtered := *wrap(e, skip)
+	filtered.filter = filter
+	filtered.filtered = nil
+	return &filtered
+}
+
+// WrapUnexpected wraps err with a stacktrace pointing to the line of code
+// that called WrapUnexpected, in the same way as Wrap, unless it matches one of
+// the expected errors with errors.Is. Expected errors, such as io.EOF used for
+// control flow, are returned unchanged. If err is nil, nil is returned.
+func WrapUnexpected(err error, expected ...error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, target := range expected {
+		if baseErrors.Is(err, target) {
+			return err
+		}
+	}
+
+	return wrap(err, 0)
+}
+
+// FirstWrapped returns the first non-nil error in errs, wrapped as by Wrap
+// with a stacktrace pointing to the line of code that called FirstWrapped. It
+// returns nil if every error is nil. This suits cleanup that makes several
+// calls, such as Close, where only the first failure is of interest.
+func FirstWrapped(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return wrap(err, 0)
+		}
+	}
+	return nil
+}
+
+// WrapEach wraps every non-nil error in errs with a new stacktrace pointing to
+// the line of code that called WrapEach. Unlike Wrap, errors that are already
+// an *Error are wrapped again. Each error is prefixed with prefixFmt formatted
+// with its index, e.g. "worker %d". Nil entries remain nil.
+func WrapEach(errs []error, prefixFmt string) []error {
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2, stack[:])
+	maxDepth := len(stack)
+	stack = stack[:length]
+	created := now()
+
+	wrapped := make([]error, len(errs))
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		wrapped[i] = &Error{
+			Err:      err,
+			stack:    stack,
+			prefix:   fmt.Sprintf(prefixFmt, i),
+			maxDepth: maxDepth,
+			created:  created,
+		}
+	}
+
+	return wrapped
+}
+
+// Trace captures a stacktrace pointing to the line of code that called it,
+// and returns a function that wraps *errp with that stacktrace if it is not
+// nil. It is intended to be deferred with a named error result, recording the
+// stack at function entry rather than at the return:
+//
+//	func load() (err error) {
+//	    defer errors.Trace(&err)()
+//	    ...
+//	}
+//
+// As with Wrap, an *Error is not wrapped again.
+func Trace(errp *error) func() {
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2, stack[:])
+	created := now()
+
+	return func() {
+		if *errp == nil {
+			return
+		}
+		if _, ok := (*errp).(*Error); ok {
+			return
+		}
+		*errp = &Error{
+			Err:      *errp,
+			stack:    stack[:length],
+			maxDepth: len(stack),
+			created:  created,
+		}
+	}
+}
+
+// Must returns v if err is nil, and otherwise panics with err as an *Error.
+// As with Wrap, an *Error is used as is, any other error gets a stacktrace
+// pointing to the line of code that called Must. It is intended for test setup
+// and simple programs where failing fast is acceptable.
+func Must[T any](v T, err error) T {
+	if err != nil {
+		panic(wrap(err, 0))
+	}
+	return v
+}
+
+// RecoverStack returns the program counters of the stack of a panicking
+// goroutine, starting at the function that panicked. It must be called
+// directly by a deferred function after recovering, and removes that function
+// and the runtime's panic handling from the stack, so that the stack points at
+// the faulting line for both runtime errors and explicit calls to panic. If
+// the goroutine is not panicking the stack starts at the caller of
+// RecoverStack.
+func RecoverStack() []uintptr {
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2, stack[:])
+	stack = stack[:length]
+
+	// stack[0] is the deferred function, called by the runtime's panic
+	// handling if the goroutine is panicking.
+	start := 1
+	for start < len(stack) && isRuntimeFrame(stack[start]) {
+		start++
+	}
+
+	if start == 1 {
+		return stack
+	}
+	return stack[start:]
+}
+
+// CaptureHere returns the program counters of the stack, starting at the
+// caller of CaptureHere. It is intended to record where asynchronous work was
+// submitted, to be attached to any error it produces with WrapWithCaptured.
+func CaptureHere() []uintptr {
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2, stack[:])
+	return stack[:length]
+}
+
+// WrapWithCaptured wraps err with a stack previously captured by CaptureHere
+// or runtime.Callers, rather than the current one. Unlike Wrap, an *Error is
+// wrapped again. If err is nil, nil is returned.
+func WrapWithCaptured(err error, pcs []uintptr) *Error {
+	if err == nil {
+		return nil
 	}
 
 	return &Error{
-		Err:    err.Err,
-		stack:  err.stack,
-		prefix: prefix,
+		Err:      err,
+		stack:    pcs,
+		maxDepth: MaxStackDepth,
+		created:  now(),
+	}
+}
+
+// isRuntimeFrame reports whether pc is in a function of package runtime.
+func isRuntimeFrame(pc uintptr) bool {
+	fn := runtime.FuncForPC(pc - 1)
+	return fn != nil && strings.HasPrefix(fn.Name(), "runtime.")
+}
+
+// JoinWithStack returns an Error wrapping errors.Join(errs...), with a
+// stacktrace pointing to the line of code that called JoinWithStack. Nil
+// errors are discarded, and nil is returned if every error is nil. Because the
+// joined error is wrapped, errors.Is and errors.As still consider each of the
+// joined errors.
+func JoinWithStack(errs ...error) error {
+	joined := baseErrors.Join(errs...)
+	if joined == nil {
+		return nil
 	}
 
+	return newSkip(joined, 0)
 }
 
+// TraceErrorfOperands makes Errorf also wrap each operand of a %w verb that
+// does not already contain an *Error, so that both the new error and the error
+// it wraps have a stacktrace. The default is false, as this allocates an extra
+// stack for each operand.
+var TraceErrorfOperands = false
+
 // Errorf creates a new error with the given message. You can use it
 // as a drop-in replacement for fmt.Errorf() to provide descriptive
 // errors in return values.
 func Errorf(format string, a ...interface{}) error {
+	if TraceErrorfOperands {
+		a = traceOperands(format, a)
+	}
 	return Wrap(fmt.Errorf(format, a...), 1)
 }
 
-// Error returns the underlying error's message.
+// traceOperands returns a copy of a in which each error operand of a %w verb
+// in format that contains no *Error is wrapped with a stacktrace pointing to
+// the caller of Errorf.
+func traceOperands(format string, a []interface{}) []interface{} {
+	traced := make([]interface{}, len(a))
+	copy(traced, a)
+
+	for _, i := range wrappedOperands(format) {
+		if i < 0 || i >= len(traced) {
+			continue
+		}
+		if err, ok := traced[i].(error); ok && firstError(err) == nil {
+			traced[i] = wrap(err, 1)
+		}
+	}
+
+	return traced
+}
+
+// wrappedOperands returns the indexes of the arguments consumed by %w verbs in
+// format, following the argument numbering rules of package fmt.
+func wrappedOperands(format string) []int {
+	var indexes []int
+	arg := 0
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		// skip flags, width and precision, which may consume arguments
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if c == '[' {
+				end := strings.IndexByte(format[i:], ']')
+				if end < 0 {
+					return indexes
+				}
+				if n, err := strconv.Atoi(format[i+1 : i+end]); err == nil {
+					arg = n - 1
+				}
+				i += end
+			} else if c == '*' {
+				arg++
+			} else if !strings.ContainsRune("+-# 0123456789.", rune(c)) {
+				break
+			}
+		}
+		if i >= len(format) {
+			break
+		}
+
+		switch format[i] {
+		case '%':
+		case 'w':
+			indexes = append(indexes, arg)
+			arg++
+		default:
+			arg++
+		}
+	}
+
+	return indexes
+}
+
+// LazyErrorf creates a new error in the same way as Errorf, but the message is
+// not formatted until it is first needed. The stacktrace is still captured
+// immediately. This avoids the cost of formatting errors that are usually
+// discarded. The arguments must not be modified after the call.
+func LazyErrorf(format string, a ...interface{}) error {
+	return newSkip(&lazyError{format: format, args: a}, 0)
+}
+
+// lazyError formats its message with fmt.Errorf on first use.
+type lazyError struct {
+	format string
+	args   []interface{}
+
+	once sync.Once
+	err  error
+}
+
+func (e *lazyError) formatted() error {
+	e.once.Do(func() {
+		e.err = fmt.Errorf(e.format, e.args...)
+		e.args = nil
+	})
+	return e.err
+}
+
+func (e *lazyError) Error() string {
+	return e.formatted().Error()
+}
+
+func (e *lazyError) Unwrap() error {
+	return baseErrors.Unwrap(e.formatted())
+}
+
+// Is and As delegate to the formatted error, which Unwrap cannot expose if it
+// wraps several errors with multiple %w verbs.
+func (e *lazyError) Is(target error) bool {
+	return baseErrors.Is(e.formatted(), target)
+}
+
+func (e *lazyError) As(target interface{}) bool {
+	return baseErrors.As(e.formatted(), target)
+}
+
+// Error returns the underlying error's message, followed by its origin if
+// AppendOriginToMessage is set, and passed through Redactor if it is set.
 func (err *Error) Error() string {
 
 	msg := err.Err.Error()
 	if err.prefix != "" {
-		msg = fmt.Sprintf("%s: %s", err.prefix, msg)
+		msg = err.prefix + PrefixSeparator + msg
 	}
 
+	msg = redact(msg)
+	if AppendOriginToMessage {
+		if origin := err.Origin(); origin != "" {
+			msg += " (" + origin + ")"
+		}
+	}
 	return msg
 }
 
 // Stack returns the callstack formatted the same way that go does
-// in runtime/debug.Stack()
+// in runtime/debug.Stack(). If CallerFirst is set the frames are in reverse
+// order.
 func (err *Error) Stack() []byte {
+	return err.formatStack("")
+}
+
+// StackReader returns a reader yielding the same output as Stack. Where
+// possible, frames are resolved and formatted one at a time as they are read,
+// so the whole stack is never held in memory. If filtering, CallerFirst or a
+// Symbolizer require all frames at once, or the frames have already been
+// resolved, the resolved frames are used instead.
+func (err *Error) StackReader() io.Reader {
+	if err.text != "" {
+		return strings.NewReader(redact(err.text))
+	}
+	if GroupByPackage {
+		return bytes.NewReader(err.Stack())
+	}
+	if err.frames != nil || err.stack == nil || !currentFilterState().none() || err.filter != nil || CallerFirst || Symbolizer != nil {
+		frames := err.StackFrames()
+		if CallerFirst {
+			frames = err.Reversed()
+		}
+		return &stackReader{next: func() (StackFrame, bool) {
+			if len(frames) == 0 {
+				return StackFrame{}, false
+			}
+			frame := frames[0]
+			frames = frames[1:]
+			return frame, true
+		}}
+	}
+
+	callers := runtime.CallersFrames(err.stack)
+	more := true
+	return &stackReader{next: func() (StackFrame, bool) {
+		if !more {
+			return StackFrame{}, false
+		}
+		var f runtime.Frame
+		f, more = callers.Next()
+		return runtimeFrame(f), true
+	}}
+}
+
+// stackReader formats the frames returned by next as they are read.
+type stackReader struct {
+	next    func() (StackFrame, bool)
+	pending []byte
+}
+
+func (r *stackReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		frame, ok := r.next()
+		if !ok {
+			return 0, io.EOF
+		}
+		r.pending = []byte(frame.String())
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// formatStack formats the callstack as Stack does, writing leafNote after the
+// frame where the stack was captured. A stack given by NewFromStackText is
+// returned as is, apart from Redactor.
+func (err *Error) formatStack(leafNote string) []byte {
+	if err.text != "" {
+		return []byte(redact(err.text))
+	}
+
 	buf := bytes.Buffer{}
 
-	for _, frame := range err.StackFrames() {
-		buf.WriteString(frame.String())
+	frames := err.StackFrames()
+	leaf := 0
+	if CallerFirst {
+		frames = err.Reversed()
+		leaf = len(frames) - 1
+	}
+
+	for i, frame := range frames {
+		if !GroupByPackage {
+			buf.WriteString(frame.String())
+		} else {
+			if i == 0 || frame.Package != frames[i-1].Package {
+				buf.WriteString(redact(frame.Package) + ":\n")
+			}
+			fmt.Fprintf(&buf, "\t%s:%d %s\n", filepath.Base(frame.displayFile()), frame.LineNumber, frame.Name)
+		}
+		if i == leaf {
+			buf.WriteString(leafNote)
+		}
 	}
 
 	return buf.Bytes()
@@ -186,35 +773,521 @@ func (err *Error) Callers() []uintptr {
 	return err.stack
 }
 
-// ErrorStack returns a string that contains both the
-// error message and the callstack.
+// Time returns when the error was created, or the zero time if
+// CaptureTimestamps was not set.
+func (err *Error) Time() time.Time {
+	return err.created
+}
+
+// now returns the current time if CaptureTimestamps is set.
+func now() time.Time {
+	if !CaptureTimestamps {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// creator returns the function that started the current goroutine, if
+// CaptureCreatedBy is set and the goroutine is not the main goroutine.
+func creator() string {
+	if !CaptureCreatedBy {
+		return ""
+	}
+
+	buf := goroutineStacks(false)
+	i := bytes.LastIndex(buf, []byte("\ncreated by "))
+	if i < 0 {
+		return ""
+	}
+	line := buf[i+len("\ncreated by "):]
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+	if end := bytes.Index(line, []byte(" in goroutine ")); end >= 0 {
+		line = line[:end]
+	}
+	return string(line)
+}
+
+// CreatedBy returns the function that started the goroutine in which the error
+// was made, e.g. "main.startWorkers", if CaptureCreatedBy was set and the
+// goroutine was not the main goroutine, or "" otherwise.
+func (err *Error) CreatedBy() string {
+	return err.creator
+}
+
+// buildRevision is the vcs.revision setting of the build info, read once.
+var buildRevision struct {
+	once     sync.Once
+	revision string
+}
+
+// revision returns the version control revision the binary was built from, if
+// CaptureRevision is set and the revision was stamped into the binary.
+func revision() string {
+	if !CaptureRevision {
+		return ""
+	}
+
+	buildRevision.once.Do(func() {
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				buildRevision.revision = setting.Value
+			}
+		}
+	})
+	return buildRevision.revision
+}
+
+// Revision returns the version control revision of the binary in which the
+// error was made, if CaptureRevision was set and go build stamped the binary
+// with one, or "" otherwise.
+func (err *Error) Revision() string {
+	return err.revision
+}
+
+// sampled reports whether a new error should capture a stacktrace, according
+// to SampleRate.
+func sampled() bool {
+	return SampleRate >= 1 || rand.Float64() < SampleRate
+}
+
+// HasStack reports whether a stacktrace was captured for the error. It is false
+// for errors skipped by SampleRate.
+func (err *Error) HasStack() bool {
+	return err.StackDepth() > 0
+}
+
+// Truncated reports whether the stack reached MaxStackDepth, as it was when
+// the stack was captured, so that deeper frames may have been lost.
+func (err *Error) Truncated() bool {
+	return err.maxDepth > 0 && err.StackDepth() >= err.maxDepth
+}
+
+// StackDepth returns the number of frames in the stack, without resolving
+// them.
+func (err *Error) StackDepth() int {
+	if err.stack == nil {
+		return len(err.frames)
+	}
+	return len(err.stack)
+}
+
+// ErrorStack returns a string that contains both the error message and the
+// callstack. Any query recorded by WithQuery is shown below the message, and
+// any snapshot attached with WithSnapshot below the frame where the stack was
+// captured. If the stack was truncated this is noted after the callstack,
+// followed by the creator of the goroutine (see CaptureCreatedBy), the
+// environment recorded by NewWithEnv and any stacks added with AddStack. If
+// the wrapped error is a Join, the ErrorStack of each joined error follows
+// under a numbered heading.
 func (err *Error) ErrorStack() string {
-	return err.TypeName() + " " + err.Error() + "\n" + string(err.Stack())
+	str := err.TypeName() + " " + err.Error() + "\n" + err.queryString() + string(err.formatStack(err.snapshotString()))
+	if err.Truncated() {
+		str += fmt.Sprintf("... (stack truncated at %d frames)\n", err.maxDepth)
+	}
+	if err.creator != "" {
+		str += "created by " + redact(err.creator) + "\n"
+	}
+	if env, ok := err.metadata[envKey].(Env); ok {
+		str += env.String()
+	}
+	str += err.labeledStacksString()
+	if joined, ok := err.Err.(interface{ Unwrap() []error }); ok {
+		str += joinedErrorStack(joined.Unwrap())
+	}
+	return str
+}
+
+// ErrorStack returns the ErrorStack of err if it is an *Error. If err is a
+// Join, its message is followed by the ErrorStack of each joined error under a
+// numbered heading. Otherwise only the type and message are returned.
+func ErrorStack(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	switch e := err.(type) {
+	case *Error:
+		return e.ErrorStack()
+	case interface{ Unwrap() []error }:
+		return typeName(err) + " " + err.Error() + "\n" + joinedErrorStack(e.Unwrap())
+	}
+
+	return typeName(err) + " " + err.Error() + "\n"
+}
+
+func joinedErrorStack(errs []error) string {
+	buf := bytes.Buffer{}
+
+	for i, e := range errs {
+		fmt.Fprintf(&buf, "\n--- [%d/%d] ---\n", i+1, len(errs))
+		buf.WriteString(ErrorStack(e))
+	}
+
+	return buf.String()
 }
 
 // StackFrames returns an array of frames containing information about the
-// stack.
+// stack. The frames are filtered according to AppFramesOnly,
+// SkipStdlibFrames and Filters, then by any filter given to WrapFiltered.
 func (err *Error) StackFrames() []StackFrame {
 	if err.frames == nil {
-		err.frames = make([]StackFrame, len(err.stack))
+		err.frames = resolveFrames(err.stack)
+	}
 
-		for i, pc := range err.stack {
-			err.frames[i] = NewStackFrame(pc)
+	state := currentFilterState()
+	if state.none() && err.filter == nil {
+		return err.frames
+	}
+	if err.filtered == nil || err.filteredBy != state {
+		err.filtered = state.apply(err.frames)
+		if err.filter != nil {
+			filtered := make([]StackFrame, 0, len(err.filtered))
+			for _, frame := range err.filtered {
+				if err.filter(frame) {
+					filtered = append(filtered, frame)
+				}
+			}
+			err.filtered = filtered
 		}
+		err.filteredBy = state
+	}
+	return err.filtered
+}
+
+// Compact returns a copy of err with its stacks resolved to frames, and the
+// program counters discarded. Compacted errors are cheaper to retain for a long
+// time, e.g. in a cache, as they hold only file names, line numbers and
+// function names. ErrorStack still works, but frames show a program counter of
+// 0x0 and Func returns nil.
+func (err *Error) Compact() *Error {
+	if err.frames == nil {
+		err.frames = resolveFrames(err.stack)
 	}
 
-	return err.frames
+	compacted := *err
+	compacted.stack = nil
+	compacted.frames = compactFrames(err.frames)
+	compacted.filtered = nil
+	compacted.filteredBy = filterState{}
+
+	compacted.labeled = nil
+	for i := range err.labeled {
+		s := &err.labeled[i]
+		if s.frames == nil {
+			s.frames = resolveFrames(s.stack)
+		}
+		compacted.labeled = append(compacted.labeled, labeledStack{label: s.label, frames: compactFrames(s.frames), at: s.at})
+	}
+	return &compacted
+}
+
+// TrimStack returns a copy of err whose stack keeps only the top n frames,
+// those closest to where the error occurred, e.g. before storing it somewhere
+// of limited size. Unlike MaxStackDepth or JSONStackDepth, the frames are
+// discarded rather than hidden, and if n > 0 the copy reports itself
+// Truncated. err is not modified. If the stack has no more than n frames the
+// copy is identical. A trimmed stack given by NewFromStackText is shown as the
+// frames parsed from it, rather than the original text.
+func (err *Error) TrimStack(n int) *Error {
+	if err.frames == nil {
+		err.frames = resolveFrames(err.stack)
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	trimmed := *err
+	if n >= len(err.frames) {
+		return &trimmed
+	}
+
+	trimmed.stack = nil
+	trimmed.text = ""
+	trimmed.frames = append([]StackFrame{}, err.frames[:n]...)
+	trimmed.maxDepth = n
+	trimmed.filtered = nil
+	trimmed.filteredBy = filterState{}
+	return &trimmed
+}
+
+// compactFrames returns a copy of frames without program counters.
+func compactFrames(frames []StackFrame) []StackFrame {
+	compacted := make([]StackFrame, len(frames))
+	for i, frame := range frames {
+		frame.ProgramCounter = 0
+		compacted[i] = frame
+	}
+	return compacted
+}
+
+// ResymbolizeWith resolves each captured program counter with lookup, e.g.
+// against the symbol table of the binary that produced the error, falling back
+// to the runtime when lookup returns false. The error itself is not modified.
+func (err *Error) ResymbolizeWith(lookup func(pc uintptr) (StackFrame, bool)) []StackFrame {
+	pcs := err.stack
+	if pcs == nil {
+		pcs = make([]uintptr, len(err.frames))
+		for i, frame := range err.frames {
+			pcs[i] = frame.ProgramCounter
+		}
+	}
+
+	frames := make([]StackFrame, 0, len(pcs))
+	for _, pc := range pcs {
+		frame, ok := lookup(pc)
+		if !ok {
+			frames = append(frames, resolveFrames([]uintptr{pc})...)
+			continue
+		}
+		frame.ProgramCounter = pc
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+// topFrame returns the frame where the stack was captured, resolving only that
+// frame if the stack has not yet been symbolized.
+func (err *Error) topFrame() (StackFrame, bool) {
+	if err.frames != nil {
+		if len(err.frames) == 0 {
+			return StackFrame{}, false
+		}
+		return err.frames[0], true
+	}
+	if len(err.stack) == 0 {
+		return StackFrame{}, false
+	}
+	return resolveFrames(err.stack[:1])[0], true
+}
+
+// Origin returns the file name and line number where the stack was captured,
+// e.g. "order.go:42", or "" if there is no stack.
+func (err *Error) Origin() string {
+	frame, ok := err.topFrame()
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(frame.displayFile()), frame.LineNumber)
+}
+
+// OriginMatches reports whether the stack was captured at the given line of a
+// file with the same base name as file. It is intended for tests asserting the
+// exact point at which an error was wrapped.
+func (err *Error) OriginMatches(file string, line int) bool {
+	frame, ok := err.topFrame()
+	return ok && filepath.Base(frame.File) == filepath.Base(file) && frame.LineNumber == line
+}
+
+// WrapSites returns the file name and line number, e.g. "order.go:42", of
+// each call to WrapPrefix or WrapPrefixf that returned this error, earliest
+// first. As WrapPrefix keeps the stack of an *Error, these show where it was
+// annotated after the stack was captured. Wrap returns an *Error unmodified,
+// so is not recorded. The sites are passed through Redactor if it is set.
+func (err *Error) WrapSites() []string {
+	if Redactor == nil {
+		return err.sites
+	}
+
+	sites := make([]string, len(err.sites))
+	for i, site := range err.sites {
+		sites[i] = redact(site)
+	}
+	return sites
+}
+
+// Summary returns a single line combining the type, origin and message of the
+// error, e.g. "*app.NotFound at order.go:42: user not found". Unlike
+// ErrorStack no callstack is included, and only the top frame is resolved.
+func (err *Error) Summary() string {
+	origin := err.Origin()
+	if origin == "" {
+		return err.TypeName() + ": " + err.Error()
+	}
+	return err.TypeName() + " at " + origin + ": " + err.Error()
+}
+
+// ErrorIdentity identifies where and what kind of error occurred. It is
+// comparable, so it can be used as a map key, e.g. to count errors by origin.
+type ErrorIdentity struct {
+	TypeName     string
+	TopFrameFile string
+	TopFrameLine int
+}
+
+// Identity returns the type name of the error and the location where its
+// stack was captured. The message is intentionally excluded, as it often
+// contains values that vary between occurrences of the same error.
+func (err *Error) Identity() ErrorIdentity {
+	frame, _ := err.topFrame()
+	return ErrorIdentity{
+		TypeName:     err.TypeName(),
+		TopFrameFile: frame.File,
+		TopFrameLine: frame.LineNumber,
+	}
+}
+
+// StableFingerprint returns a hash of the type name of the error and the
+// functions of the top depth frames of StackFrames, or of every frame if depth
+// is zero or negative. Unlike Identity, line numbers are excluded, so the
+// fingerprint survives edits that move code without changing the call path,
+// making it suitable as a deduplication key for alerts. The tradeoff is that
+// distinct errors raised from the same function with the same type share a
+// fingerprint.
+func (err *Error) StableFingerprint(depth int) string {
+	frames := err.StackFrames()
+	if depth > 0 && len(frames) > depth {
+		frames = frames[:depth]
+	}
+
+	h := fnv.New64a()
+	io.WriteString(h, err.TypeName())
+	for _, frame := range frames {
+		io.WriteString(h, "\n"+frame.Package+"."+frame.Name)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// InTest reports whether the stack passes through test code, that is, any of
+// its frames is in a _test.go file or in package testing. Only the captured
+// frames are checked, ignoring any filtering.
+func (err *Error) InTest() bool {
+	if err.frames == nil {
+		err.frames = resolveFrames(err.stack)
+	}
+
+	for _, frame := range err.frames {
+		if frame.Package == "testing" || strings.HasSuffix(frame.File, "_test.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupByFingerprint groups errs by the StableFingerprint, of every frame, of
+// each *Error, or by message for other errors, e.g. to report the distinct
+// kinds of error in a batch. Errors keep their order within each group. Nil
+// errors are skipped.
+func GroupByFingerprint(errs []error) map[string][]error {
+	groups := map[string][]error{}
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		key := err.Error()
+		if e, ok := err.(*Error); ok {
+			key = e.StableFingerprint(0)
+		}
+		groups[key] = append(groups[key], err)
+	}
+	return groups
+}
+
+// Reversed returns the frames of StackFrames in caller first order, with the
+// outermost caller first and the frame where the stack was captured last.
+func (err *Error) Reversed() []StackFrame {
+	frames := err.StackFrames()
+
+	reversed := make([]StackFrame, len(frames))
+	for i, frame := range frames {
+		reversed[len(frames)-1-i] = frame
+	}
+
+	return reversed
+}
+
+// FramesBetween returns the frames from the first frame whose function
+// matches top down to the first following frame that matches bottom,
+// inclusive. A frame matches a marker if the marker equals either its Name or
+// its fully qualified Package.Name. If either marker is not found an empty
+// slice is returned.
+func (err *Error) FramesBetween(top, bottom string) []StackFrame {
+	frames := err.StackFrames()
+
+	for i := range frames {
+		if !frames[i].matches(top) {
+			continue
+		}
+		for j := i; j < len(frames); j++ {
+			if frames[j].matches(bottom) {
+				return frames[i : j+1]
+			}
+		}
+		break
+	}
+
+	return []StackFrame{}
+}
+
+// StackDiff compares the stacks of two errors. Stacks are leaf first, so the
+// frames shared by both errors are found at the end. StackDiff returns those
+// common frames along with the frames preceding them in a and in b, where the
+// two code paths diverge. Frames are compared by file, line and function.
+func StackDiff(a, b *Error) (common []StackFrame, aOnly []StackFrame, bOnly []StackFrame) {
+	aFrames, bFrames := a.StackFrames(), b.StackFrames()
+
+	i, j := len(aFrames), len(bFrames)
+	for i > 0 && j > 0 && aFrames[i-1].sameLocation(&bFrames[j-1]) {
+		i--
+		j--
+	}
+
+	return aFrames[i:], aFrames[:i], bFrames[:j]
 }
 
 // TypeName returns the type this error. e.g. *errors.stringError.
 func (err *Error) TypeName() string {
-	if _, ok := err.Err.(uncaughtPanic); ok {
+	return typeName(err.Err)
+}
+
+// typeName returns the name reported by TypeName for an error.
+func typeName(err error) string {
+	switch e := err.(type) {
+	case uncaughtPanic:
 		return "panic"
+	case decodedError:
+		return e.typeName
+	case decodedJoin:
+		return e.typeName
 	}
-	return reflect.TypeOf(err.Err).String()
+	return reflect.TypeOf(err).String()
 }
 
 // Return the wrapped error (implements api for As function).
 func (err *Error) Unwrap() error {
 	return err.Err
 }
+
+// InnerError returns the wrapped error, err.Err, e.g. to switch on its
+// concrete type. It is equivalent to the Unwrap method and never traverses
+// further into the chain.
+func (err *Error) InnerError() error {
+	return err.Err
+}
+
+// IsNil reports whether err is nil, either as a nil interface or as an
+// interface holding a nil value such as a nil *Error. Comparing such an
+// interface with nil is false, which is a common source of bugs. IsNil uses
+// reflection for anything but a nil interface or *Error, so it should be used
+// at API boundaries rather than in hot paths.
+func IsNil(err error) bool {
+	if err == nil {
+		return true
+	}
+	if e, ok := err.(*Error); ok {
+		return e == nil
+	}
+
+	v := reflect.ValueOf(err)
+	switch v.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
@@ -9,6 +9,8 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func BenchmarkStackFormat(b *testing.B) {
@@ -429,3 +431,941 @@ func TestNil(t *testing.T) {
 		t.Errorf("Joined, Wrapped, WrapPrefix'ed nil errors not nil: %v", err2)
 	}
 }
+
+func TestFramesBetween(t *testing.T) {
+	err := &Error{Err: io.EOF, frames: []StackFrame{
+		{Package: "example.com/app/repo", Name: "(*Store).Get"},
+		{Package: "example.com/app/service", Name: "Lookup"},
+		{Package: "example.com/app/http", Name: "Handler"},
+		{Package: "net/http", Name: "(*conn).serve"},
+	}}
+
+	frames := err.FramesBetween("example.com/app/service.Lookup", "Handler")
+	if len(frames) != 2 || frames[0].Name != "Lookup" || frames[1].Name != "Handler" {
+		t.Errorf("Wrong frames between markers: %#v", frames)
+	}
+
+	if frames := err.FramesBetween("Handler", "Lookup"); frames == nil || len(frames) != 0 {
+		t.Errorf("Expected empty slice for out-of-order markers: %#v", frames)
+	}
+
+	if frames := err.FramesBetween("missing", "Handler"); frames == nil || len(frames) != 0 {
+		t.Errorf("Expected empty slice for missing marker: %#v", frames)
+	}
+}
+
+func TestNewSkip(t *testing.T) {
+	err := NewSkip(0, "foo").(*Error)
+	if err.Error() != "foo" {
+		t.Errorf("Wrong message")
+	}
+
+	bs := [][]uintptr{NewSkip(0, "foo").(*Error).stack, callers()}
+	if err := compareStacks(bs[0], bs[1]); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	helper := func() error {
+		return NewSkip(1, io.EOF)
+	}
+	bs = [][]uintptr{helper().(*Error).stack, callers()}
+	if err := compareStacks(bs[0], bs[1]); err != nil {
+		t.Errorf("Skipped stack didn't match")
+		t.Errorf(err.Error())
+	}
+}
+
+func TestWrapEach(t *testing.T) {
+	inner := New("bar")
+	errs, expected := WrapEach([]error{io.EOF, nil, inner}, "worker %d"), callers()
+
+	if len(errs) != 3 || errs[1] != nil {
+		t.Fatalf("Wrong wrapped errors: %v", errs)
+	}
+
+	if errs[0].Error() != "worker 0: EOF" || errs[2].Error() != "worker 2: bar" {
+		t.Errorf("Wrong messages: %v", errs)
+	}
+
+	if errs[2].(*Error).Err != inner {
+		t.Errorf("*Error was not wrapped")
+	}
+
+	if err := compareStacks(errs[0].(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+}
+
+func TestErrorStackJoin(t *testing.T) {
+	first, second := New("first").(*Error), New("second").(*Error)
+	joined := errors.Join(first, second)
+
+	expected := "*errors.joinError first\nsecond\n" +
+		"\n--- [1/2] ---\n" + first.ErrorStack() +
+		"\n--- [2/2] ---\n" + second.ErrorStack()
+	if stack := ErrorStack(joined); stack != expected {
+		t.Errorf("Wrong joined ErrorStack:\n%s", stack)
+	}
+
+	wrapped := Wrap(joined, 0).(*Error)
+	if stack := wrapped.ErrorStack(); !strings.HasSuffix(stack, "\n--- [2/2] ---\n"+second.ErrorStack()) {
+		t.Errorf("Wrapped Join does not include joined stacks:\n%s", stack)
+	}
+
+	if stack := ErrorStack(first); stack != first.ErrorStack() {
+		t.Errorf("Single error ErrorStack changed:\n%s", stack)
+	}
+
+	if stack := ErrorStack(io.EOF); stack != "*errors.errorString EOF\n" {
+		t.Errorf("Wrong plain ErrorStack: %q", stack)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	defer func(depth int) { MaxStackDepth = depth }(MaxStackDepth)
+
+	err := New("foo").(*Error)
+	if err.Truncated() || strings.HasSuffix(err.ErrorStack(), "frames)\n") {
+		t.Errorf("Stack was truncated")
+	}
+
+	MaxStackDepth = 2
+	err = New("foo").(*Error)
+	MaxStackDepth = 50
+
+	if !err.Truncated() {
+		t.Errorf("Stack was not truncated")
+	}
+
+	if !strings.HasSuffix(err.ErrorStack(), "... (stack truncated at 2 frames)\n") {
+		t.Errorf("ErrorStack does not note truncation:\n%s", err.ErrorStack())
+	}
+
+	if prefixed := WrapPrefix(err, "prefix", 0).(*Error); !prefixed.Truncated() {
+		t.Errorf("WrapPrefix lost truncation")
+	}
+}
+
+func TestStackDiff(t *testing.T) {
+	root := StackFrame{File: "main.go", LineNumber: 10, Package: "main", Name: "main"}
+	handler := StackFrame{File: "app.go", LineNumber: 20, Package: "app", Name: "Handle"}
+	dialA := StackFrame{File: "db.go", LineNumber: 30, Package: "db", Name: "Dial"}
+	dialB := StackFrame{File: "db.go", LineNumber: 35, Package: "db", Name: "Dial"}
+	retry := StackFrame{File: "db.go", LineNumber: 50, Package: "db", Name: "Retry"}
+
+	a := &Error{Err: io.EOF, frames: []StackFrame{dialA, handler, root}}
+	b := &Error{Err: io.EOF, frames: []StackFrame{dialB, retry, handler, root}}
+
+	common, aOnly, bOnly := StackDiff(a, b)
+	if !reflect.DeepEqual(common, []StackFrame{handler, root}) {
+		t.Errorf("Wrong common frames: %#v", common)
+	}
+	if !reflect.DeepEqual(aOnly, []StackFrame{dialA}) {
+		t.Errorf("Wrong frames only in a: %#v", aOnly)
+	}
+	if !reflect.DeepEqual(bOnly, []StackFrame{dialB, retry}) {
+		t.Errorf("Wrong frames only in b: %#v", bOnly)
+	}
+
+	common, aOnly, bOnly = StackDiff(a, a)
+	if len(common) != 3 || len(aOnly) != 0 || len(bOnly) != 0 {
+		t.Errorf("Identical stacks differ")
+	}
+}
+
+func TestPrefixSeparator(t *testing.T) {
+	defer func(separator string) { PrefixSeparator = separator }(PrefixSeparator)
+	PrefixSeparator = " > "
+
+	err := WrapPrefix(WrapPrefix(io.EOF, "inner", 0), "outer", 0)
+	if err.Error() != "outer > inner > EOF" {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Separator broke Is")
+	}
+}
+
+func TestInnerError(t *testing.T) {
+	joined := errors.Join(io.EOF, io.ErrUnexpectedEOF)
+	if inner := New(joined).(*Error).InnerError(); inner != joined {
+		t.Errorf("Wrong inner error: %#v", inner)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var expected []uintptr
+	traced := func(ret error) (err error) {
+		defer Trace(&err)()
+		expected = callersSkip(0)
+		return ret
+	}
+
+	if err := traced(nil); err != nil {
+		t.Errorf("nil error was wrapped: %v", err)
+	}
+
+	err, ok := traced(io.EOF).(*Error)
+	if !ok || err.Err != io.EOF {
+		t.Fatalf("Error was not wrapped")
+	}
+	if err := compareStacks(err.stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	original := New(io.EOF)
+	if traced(original) != original {
+		t.Errorf("*Error was wrapped again")
+	}
+}
+
+func TestJoinWithStack(t *testing.T) {
+	if JoinWithStack(nil, nil) != nil {
+		t.Errorf("Joined nil errors not nil")
+	}
+
+	err, expected := JoinWithStack(io.EOF, nil, errorString("foo")), callers()
+
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Join did not return an *Error")
+	}
+	if err := compareStacks(e.stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	if err.Error() != "EOF\nfoo" {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+
+	var errStr errorString
+	if !errors.Is(err, io.EOF) || !errors.As(err, &errStr) {
+		t.Errorf("Joined errors not found by Is and As")
+	}
+
+	if joined, ok := e.Unwrap().(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 2 {
+		t.Errorf("Wrapped error is not a Join")
+	}
+}
+
+func TestMust(t *testing.T) {
+	if v := Must(42, nil); v != 42 {
+		t.Errorf("Wrong value: %d", v)
+	}
+
+	var expected []uintptr
+	defer func() {
+		err, ok := recover().(*Error)
+		if !ok {
+			t.Fatalf("Must did not panic with an *Error")
+		}
+		if err.Err != io.EOF {
+			t.Errorf("Wrong error: %v", err.Err)
+		}
+		if err := compareStacks(err.stack, expected); err != nil {
+			t.Errorf("Stack didn't match")
+			t.Errorf(err.Error())
+		}
+	}()
+
+	expected = callers()
+	Must(0, io.EOF)
+}
+
+func TestCallerFirst(t *testing.T) {
+	defer func(callerFirst bool) { CallerFirst = callerFirst }(CallerFirst)
+
+	first := StackFrame{File: "leaf.go", LineNumber: 1}
+	second := StackFrame{File: "main.go", LineNumber: 2}
+	err := &Error{Err: io.EOF, frames: []StackFrame{first, second}}
+
+	if reversed := err.Reversed(); !reflect.DeepEqual(reversed, []StackFrame{second, first}) {
+		t.Errorf("Wrong reversed frames: %#v", reversed)
+	}
+	if err.StackFrames()[0] != first {
+		t.Errorf("Reversed modified the stored frames")
+	}
+
+	leafFirst := string(err.Stack())
+	CallerFirst = true
+	if stack := string(err.Stack()); stack != second.String()+first.String() || stack == leafFirst {
+		t.Errorf("Wrong caller first stack:\n%s", stack)
+	}
+}
+
+func TestCaptureTimestamps(t *testing.T) {
+	defer func(capture bool) { CaptureTimestamps = capture }(CaptureTimestamps)
+
+	if !New("foo").(*Error).Time().IsZero() {
+		t.Errorf("Timestamp captured by default")
+	}
+
+	CaptureTimestamps = true
+	before := time.Now()
+	created := New("foo").(*Error).Time()
+	if created.Before(before) || created.After(time.Now()) {
+		t.Errorf("Wrong timestamp: %v", created)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	err, line := New(errorString("user not found")).(*Error), callerLine()
+
+	if origin := err.Origin(); origin != fmt.Sprintf("error_test.go:%d", line) {
+		t.Errorf("Wrong origin: %s", origin)
+	}
+	if err.frames != nil {
+		t.Errorf("Origin resolved every frame")
+	}
+
+	expected := fmt.Sprintf("errors.errorString at error_test.go:%d: user not found", line)
+	if summary := err.Summary(); summary != expected {
+		t.Errorf("Wrong summary: %s", summary)
+	}
+
+	stackless := &Error{Err: io.EOF, frames: []StackFrame{}}
+	if summary := stackless.Summary(); summary != "*errors.errorString: EOF" {
+		t.Errorf("Wrong summary without a stack: %s", summary)
+	}
+}
+
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+func TestIsNil(t *testing.T) {
+	var e *Error
+	var err error = e
+
+	if err == nil || !IsNil(err) {
+		t.Errorf("Typed nil *Error is not nil")
+	}
+	if !IsNil(nil) {
+		t.Errorf("nil is not nil")
+	}
+
+	var c *cyclicError
+	if !IsNil(c) {
+		t.Errorf("Typed nil pointer is not nil")
+	}
+
+	if IsNil(io.EOF) || IsNil(New(io.EOF)) || IsNil(errorString("")) {
+		t.Errorf("Error is nil")
+	}
+}
+
+func TestResymbolizeWith(t *testing.T) {
+	err := New("foo").(*Error)
+	original := err.StackFrames()
+
+	lookup := func(pc uintptr) (StackFrame, bool) {
+		if pc != err.stack[0] {
+			return StackFrame{}, false
+		}
+		return StackFrame{File: "remote.go", LineNumber: 7, Package: "remote", Name: "Func"}, true
+	}
+
+	frames := err.ResymbolizeWith(lookup)
+	if len(frames) != len(original) {
+		t.Fatalf("Wrong number of frames: %d", len(frames))
+	}
+	expected := StackFrame{File: "remote.go", LineNumber: 7, Package: "remote", Name: "Func", ProgramCounter: err.stack[0]}
+	if frames[0] != expected {
+		t.Errorf("Lookup not used: %#v", frames[0])
+	}
+	if !reflect.DeepEqual(frames[1:], original[1:]) {
+		t.Errorf("Runtime fallback not used")
+	}
+	if !reflect.DeepEqual(err.StackFrames(), original) {
+		t.Errorf("ResymbolizeWith modified the error")
+	}
+}
+
+type countingStringer int
+
+func (c *countingStringer) String() string {
+	*c++
+	return "counted"
+}
+
+func TestLazyErrorf(t *testing.T) {
+	var count countingStringer
+	err, expected := LazyErrorf("%s: %w", &count, io.EOF), callers()
+
+	if count != 0 {
+		t.Errorf("Message formatted eagerly")
+	}
+	if err := compareStacks(err.(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	if err.Error() != "counted: EOF" || err.Error() != "counted: EOF" {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("Message not memoized: formatted %d times", count)
+	}
+
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Wrapped error not found")
+	}
+
+	multi := LazyErrorf("%w, %w", io.EOF, errorString("custom"))
+	if !errors.Is(multi, io.EOF) {
+		t.Errorf("First of several wrapped errors not found")
+	}
+	var custom errorString
+	if !errors.As(multi, &custom) || custom != "custom" {
+		t.Errorf("Second of several wrapped errors not found")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	counts := map[ErrorIdentity]int{}
+	for i := 0; i < 3; i++ {
+		counts[Errorf("attempt %d", i).(*Error).Identity()]++
+	}
+	counts[New(io.EOF).(*Error).Identity()]++
+
+	if len(counts) != 2 {
+		t.Errorf("Wrong number of identities: %v", counts)
+	}
+
+	identity := New(errorString("foo")).(*Error).Identity()
+	if identity.TypeName != "errors.errorString" || !strings.HasSuffix(identity.TopFrameFile, "error_test.go") || identity.TopFrameLine == 0 {
+		t.Errorf("Wrong identity: %#v", identity)
+	}
+}
+
+func TestRecoverStack(t *testing.T) {
+	recovered := func(f func()) (pcs []uintptr) {
+		defer func() {
+			recover()
+			pcs = RecoverStack()
+		}()
+		f()
+		return nil
+	}
+
+	pcs := recovered(func() { a() })
+	if name := runtime.FuncForPC(pcs[0] - 1).Name(); name != "github.com/go-errors/errors.c" {
+		t.Errorf("Stack does not start at panic: %s", name)
+	}
+
+	var m map[string]int
+	pcs = recovered(func() { m["a"] = 1 })
+	frame := (&Error{Err: io.EOF, stack: pcs}).StackFrames()[0]
+	if !strings.HasPrefix(frame.Name, "TestRecoverStack.func") {
+		t.Errorf("Stack does not start at runtime error: %s", frame.Name)
+	}
+	if source, _ := frame.SourceLine(); source != `pcs = recovered(func() { m["a"] = 1 })` {
+		t.Errorf("Wrong faulting line: %s", source)
+	}
+
+	pcs = RecoverStack()
+	if err := compareStacks(pcs, callers()); err != nil {
+		t.Errorf("Stack without panic didn't match")
+		t.Errorf(err.Error())
+	}
+}
+
+func TestTraceErrorfOperands(t *testing.T) {
+	defer func(trace bool) { TraceErrorfOperands = trace }(TraceErrorfOperands)
+
+	if _, ok := errors.Unwrap(Errorf("read: %w", io.EOF).(*Error).Err).(*Error); ok {
+		t.Errorf("Operand traced by default")
+	}
+
+	TraceErrorfOperands = true
+	traced := New(io.ErrUnexpectedEOF)
+	err, expected := Errorf("%d%% %[3]s: %[2]w %[4]w", 100, io.EOF, "read", traced), callers()
+
+	if err.Error() != "100% read: EOF unexpected EOF" {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+
+	operands := errors.Unwrap(err).(interface{ Unwrap() []error }).Unwrap()
+	inner, ok := operands[0].(*Error)
+	if !ok || inner.Err != io.EOF {
+		t.Fatalf("Operand not traced: %#v", operands[0])
+	}
+	if err := compareStacks(inner.stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+	if operands[1] != traced {
+		t.Errorf("Traced operand was wrapped again")
+	}
+
+	if !reflect.DeepEqual(wrappedOperands("%*d %w %[1]w %%w %v"), []int{2, 0}) {
+		t.Errorf("Wrong operands: %v", wrappedOperands("%*d %w %[1]w %%w %v"))
+	}
+}
+
+func BenchmarkStackFrames(b *testing.B) {
+	b.ReportAllocs()
+	stack := deepError(30).stack
+
+	for i := 0; i < b.N; i++ {
+		err := &Error{Err: io.EOF, stack: stack}
+		_ = err.StackFrames()
+	}
+}
+
+// BenchmarkStackFramesPerPC resolves each program counter separately, as
+// StackFrames did before resolving them in a single pass.
+func BenchmarkStackFramesPerPC(b *testing.B) {
+	b.ReportAllocs()
+	stack := deepError(30).stack
+
+	for i := 0; i < b.N; i++ {
+		frames := make([]StackFrame, len(stack))
+		for i, pc := range stack {
+			frames[i] = NewStackFrame(pc)
+		}
+	}
+}
+
+// deepError returns an *Error created depth calls deep.
+func deepError(depth int) *Error {
+	if depth == 0 {
+		return New("foo").(*Error)
+	}
+	return deepError(depth - 1)
+}
+
+func TestSampleRate(t *testing.T) {
+	defer func(rate float64) { SampleRate = rate }(SampleRate)
+
+	if !New("foo").(*Error).HasStack() || !Wrap(io.EOF, 0).(*Error).HasStack() {
+		t.Errorf("Stack not captured by default")
+	}
+
+	SampleRate = 0
+	err := New("foo").(*Error)
+	if err.HasStack() || len(err.StackFrames()) != 0 || err.Truncated() {
+		t.Errorf("Stack captured when sampled out")
+	}
+	if err.Error() != "foo" || err.ErrorStack() != "*errors.errorString foo\n" {
+		t.Errorf("Sampled out error invalid: %q", err.ErrorStack())
+	}
+	if Wrap(io.EOF, 0).(*Error).HasStack() {
+		t.Errorf("Wrap captured stack when sampled out")
+	}
+}
+
+func TestWrapUnexpected(t *testing.T) {
+	if WrapUnexpected(nil, io.EOF) != nil {
+		t.Errorf("Nil error wrapped")
+	}
+
+	if err := WrapUnexpected(io.EOF, io.ErrUnexpectedEOF, io.EOF); err != io.EOF {
+		t.Errorf("Expected error wrapped: %#v", err)
+	}
+	if err := WrapUnexpected(fmt.Errorf("reading: %w", io.EOF), io.EOF); !errors.Is(err, io.EOF) || err.Error() != "reading: EOF" {
+		t.Errorf("Expected error wrapped: %#v", err)
+	}
+
+	err, expected := WrapUnexpected(io.ErrClosedPipe, io.EOF), callers()
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if err := compareStacks(err.(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+}
+
+func TestOriginMatches(t *testing.T) {
+	err, line := New(io.EOF).(*Error), callerLine()
+
+	if !err.OriginMatches("error_test.go", line) || !err.OriginMatches("/any/dir/error_test.go", line) {
+		t.Errorf("Origin didn't match: %s", err.Origin())
+	}
+	if err.OriginMatches("error_test.go", line+1) || err.OriginMatches("error.go", line) {
+		t.Errorf("Origin matched the wrong location")
+	}
+	if (&Error{Err: io.EOF}).OriginMatches("error_test.go", line) {
+		t.Errorf("Stackless error matched")
+	}
+}
+
+func TestWrapKeepDeepest(t *testing.T) {
+	if WrapKeepDeepest(nil, 0) != nil {
+		t.Errorf("Nil error wrapped")
+	}
+
+	err, expected := WrapKeepDeepest(io.EOF, 0), callers()
+	if err := compareStacks(err.(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+	if WrapKeepDeepest(err, 0) != err {
+		t.Errorf("*Error was wrapped again")
+	}
+
+	outer := fmt.Errorf("middle: %w", WrapPrefix(fmt.Errorf("inner: %w", err), "prefix", 0))
+	kept := WrapKeepDeepest(outer, 0).(*Error)
+	if err := compareStacks(kept.stack, expected); err != nil {
+		t.Errorf("Deepest stack not kept")
+		t.Errorf(err.Error())
+	}
+	if kept.Error() != "middle: prefix: inner: EOF" || !errors.Is(kept, io.EOF) {
+		t.Errorf("Wrong error: %v", kept)
+	}
+}
+
+func TestWrapWithCaptured(t *testing.T) {
+	if WrapWithCaptured(nil, CaptureHere()) != nil {
+		t.Errorf("Nil error wrapped")
+	}
+
+	pcs, expected := CaptureHere(), callers()
+	if err := compareStacks(pcs, expected); err != nil {
+		t.Errorf("Captured stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	done := make(chan *Error)
+	go func() {
+		done <- WrapWithCaptured(io.EOF, pcs)
+	}()
+	err := <-done
+
+	if err := compareStacks(err.stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+	if frames := err.StackFrames(); frames[0].Name != "TestWrapWithCaptured" {
+		t.Errorf("Wrong top frame: %s", frames[0].Name)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("Wrong error: %v", err)
+	}
+}
+
+func TestWrapSites(t *testing.T) {
+	err := New(io.EOF)
+	if sites := err.(*Error).WrapSites(); len(sites) != 0 {
+		t.Errorf("Wrong sites: %v", sites)
+	}
+
+	first, firstLine := WrapPrefix(err, "first", 0).(*Error), callerLine()
+	second, secondLine := WrapPrefix(first, "second", 0).(*Error), callerLine()
+
+	expected := []string{fmt.Sprintf("error_test.go:%d", firstLine), fmt.Sprintf("error_test.go:%d", secondLine)}
+	if sites := second.WrapSites(); !reflect.DeepEqual(sites, expected) {
+		t.Errorf("Wrong sites: %v", sites)
+	}
+	if sites := first.WrapSites(); !reflect.DeepEqual(sites, expected[:1]) {
+		t.Errorf("Earlier error modified: %v", sites)
+	}
+	if err := compareStacks(second.stack, err.(*Error).stack); err != nil {
+		t.Errorf("Original stack not kept")
+	}
+}
+
+func TestWrapPrefixf(t *testing.T) {
+	if WrapPrefixf(nil, 0, "user %d", 1) != nil {
+		t.Errorf("Nil error wrapped")
+	}
+
+	err, expected := WrapPrefixf(io.EOF, 0, "loading user %d", 42), callers()
+	if err.Error() != "loading user 42: EOF" {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+	if err := compareStacks(err.(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	wrapped, line := WrapPrefixf(err, 0, "%s", "outer").(*Error), callerLine()
+	if wrapped.Error() != "outer: loading user 42: EOF" {
+		t.Errorf("Wrong message: %s", wrapped.Error())
+	}
+	if sites := wrapped.WrapSites(); sites[len(sites)-1] != fmt.Sprintf("error_test.go:%d", line) {
+		t.Errorf("Wrong sites: %v", sites)
+	}
+}
+
+func TestStableFingerprint(t *testing.T) {
+	frames := []StackFrame{
+		{File: "/src/app/order.go", LineNumber: 42, Package: "app", Name: "Load"},
+		{File: "/src/app/main.go", LineNumber: 7, Package: "main", Name: "main"},
+	}
+	moved := []StackFrame{
+		{File: "/src/app/order.go", LineNumber: 50, Package: "app", Name: "Load"},
+		{File: "/src/app/main.go", LineNumber: 9, Package: "main", Name: "main"},
+	}
+	called := []StackFrame{
+		{File: "/src/app/order.go", LineNumber: 42, Package: "app", Name: "Load"},
+		{File: "/src/app/cli.go", LineNumber: 7, Package: "app", Name: "Run"},
+	}
+
+	err := &Error{Err: io.EOF, frames: frames}
+	fingerprint := err.StableFingerprint(0)
+	if len(fingerprint) != 16 {
+		t.Errorf("Wrong fingerprint: %s", fingerprint)
+	}
+	if (&Error{Err: io.EOF, frames: moved}).StableFingerprint(0) != fingerprint {
+		t.Errorf("Fingerprint changed with line numbers")
+	}
+	if (&Error{Err: io.EOF, frames: called}).StableFingerprint(0) == fingerprint {
+		t.Errorf("Fingerprint unchanged with call path")
+	}
+	if (&Error{Err: io.EOF, frames: called}).StableFingerprint(1) != err.StableFingerprint(1) {
+		t.Errorf("Depth not limited")
+	}
+	if (&Error{Err: errorString("EOF"), frames: frames}).StableFingerprint(0) == fingerprint {
+		t.Errorf("Fingerprint unchanged with type")
+	}
+}
+
+func TestStackReader(t *testing.T) {
+	err := New("foo").(*Error)
+
+	data, e := io.ReadAll(iotest.OneByteReader(err.StackReader()))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if err.frames != nil {
+		t.Errorf("StackReader resolved all frames")
+	}
+	if string(data) != string(err.Stack()) {
+		t.Errorf("Wrong stack:\n%s", data)
+	}
+
+	defer func(callerFirst bool) { CallerFirst = callerFirst }(CallerFirst)
+	CallerFirst = true
+	if data, _ := io.ReadAll(err.StackReader()); string(data) != string(err.Stack()) {
+		t.Errorf("Wrong caller first stack:\n%s", data)
+	}
+
+	if data, _ := io.ReadAll((&Error{Err: io.EOF}).StackReader()); len(data) != 0 {
+		t.Errorf("Stackless error read: %s", data)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	defer func(format FrameFormat) { StackFrameFormat = format }(StackFrameFormat)
+	StackFrameFormat = FormatIDE
+
+	err := WrapPrefix(New("foo"), "prefix", 0).(*Error).AddStack("retained")
+	compacted := err.Compact()
+
+	if compacted.stack != nil || compacted.labeled[0].stack != nil {
+		t.Errorf("Program counters retained")
+	}
+	for _, frame := range compacted.StackFrames() {
+		if frame.ProgramCounter != 0 {
+			t.Errorf("Program counter retained: %#v", frame)
+		}
+	}
+	if compacted.ErrorStack() != err.ErrorStack() {
+		t.Errorf("Wrong compacted stack:\n%s", compacted.ErrorStack())
+	}
+	if err.stack == nil {
+		t.Errorf("Original error modified")
+	}
+}
+
+func TestInTest(t *testing.T) {
+	defer Filters.Clear()
+	Filters.Add("none", func(StackFrame) bool { return false })
+
+	if !New("foo").(*Error).InTest() {
+		t.Errorf("Error from test not in test")
+	}
+
+	app := &Error{Err: io.EOF, frames: []StackFrame{
+		{File: "/src/app/main.go", Package: "main", Name: "main"},
+	}}
+	if app.InTest() {
+		t.Errorf("Error from app in test")
+	}
+}
+
+func TestFirstWrapped(t *testing.T) {
+	if FirstWrapped() != nil || FirstWrapped(nil, nil) != nil {
+		t.Errorf("Nil errors wrapped")
+	}
+
+	err, expected := FirstWrapped(nil, io.EOF, io.ErrClosedPipe), callers()
+	if !errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Wrong error: %v", err)
+	}
+	if err := compareStacks(err.(*Error).stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+}
+
+func TestStackTruncation(t *testing.T) {
+	defer func(depth int, truncation Truncation) {
+		MaxStackDepth, StackTruncation = depth, truncation
+	}(MaxStackDepth, StackTruncation)
+	MaxStackDepth = 5
+
+	leaf := deepError(20)
+	StackTruncation = KeepRoot
+	root := deepError(20)
+
+	if leaf.StackDepth() != 5 || root.StackDepth() != 5 || !root.Truncated() {
+		t.Errorf("Wrong depths: %d %d", leaf.StackDepth(), root.StackDepth())
+	}
+	for _, frame := range leaf.StackFrames() {
+		if frame.Name != "deepError" {
+			t.Errorf("Leaf not kept: %v", leaf.StackFrames())
+		}
+	}
+	frames := root.StackFrames()
+	if frames[len(frames)-1].Name != "goexit" || frames[len(frames)-3].Name != "TestStackTruncation" {
+		t.Errorf("Root not kept: %v", frames)
+	}
+}
+
+func TestCaptureCreatedBy(t *testing.T) {
+	defer func(capture bool) { CaptureCreatedBy = capture }(CaptureCreatedBy)
+
+	spawn := func() *Error {
+		done := make(chan *Error)
+		go func() { done <- New("foo").(*Error) }()
+		return <-done
+	}
+
+	if err := spawn(); err.CreatedBy() != "" {
+		t.Errorf("Creator captured by default: %s", err.CreatedBy())
+	}
+
+	CaptureCreatedBy = true
+	err := spawn()
+	if creator := err.CreatedBy(); !strings.HasPrefix(creator, "github.com/go-errors/errors.TestCaptureCreatedBy") {
+		t.Errorf("Wrong creator: %s", creator)
+	}
+	if !strings.Contains(err.ErrorStack(), "\ncreated by "+err.CreatedBy()+"\n") {
+		t.Errorf("Creator not in ErrorStack:\n%s", err.ErrorStack())
+	}
+}
+
+func TestGroupByPackage(t *testing.T) {
+	defer func(group bool) { GroupByPackage = group }(GroupByPackage)
+	GroupByPackage = true
+
+	err := &Error{Err: io.EOF, frames: []StackFrame{
+		{File: "/src/github.com/acme/app/order.go", LineNumber: 42, Package: "github.com/acme/app", Name: "Load"},
+		{File: "/src/github.com/acme/app/handler.go", LineNumber: 7, Package: "github.com/acme/app", Name: "(*Handler).Serve"},
+		{File: "/usr/local/go/src/net/http/server.go", LineNumber: 2136, Package: "net/http", Name: "HandlerFunc.ServeHTTP"},
+	}}
+
+	expected := "github.com/acme/app:\n" +
+		"\torder.go:42 Load\n" +
+		"\thandler.go:7 (*Handler).Serve\n" +
+		"net/http:\n" +
+		"\tserver.go:2136 HandlerFunc.ServeHTTP\n"
+	if stack := string(err.Stack()); stack != expected {
+		t.Errorf("Wrong stack:\n%s", stack)
+	}
+	if data, _ := io.ReadAll(err.StackReader()); string(data) != expected {
+		t.Errorf("Wrong stack reader output:\n%s", data)
+	}
+}
+
+func TestAppendOriginToMessage(t *testing.T) {
+	defer func(append bool) { AppendOriginToMessage = append }(AppendOriginToMessage)
+
+	err, line := WrapPrefix(io.EOF, "reading", 0).(*Error), callerLine()
+	if err.Error() != "reading: EOF" {
+		t.Errorf("Origin appended by default: %s", err.Error())
+	}
+
+	AppendOriginToMessage = true
+	if expected := fmt.Sprintf("reading: EOF (error_test.go:%d)", line); err.Error() != expected {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+	if err.frames != nil {
+		t.Errorf("All frames resolved")
+	}
+	if stackless := (&Error{Err: io.EOF}); stackless.Error() != "EOF" {
+		t.Errorf("Wrong stackless message: %s", stackless.Error())
+	}
+}
+
+func TestGroupByFingerprint(t *testing.T) {
+	load := func(i int) error { return New(fmt.Sprintf("load %d", i)) }
+	save := func(i int) error { return New(fmt.Sprintf("save %d", i)) }
+
+	var errs []error
+	for i := 0; i < 3; i++ {
+		errs = append(errs, load(i), save(i), nil, io.EOF)
+	}
+
+	groups := GroupByFingerprint(errs)
+	if len(groups) != 3 {
+		t.Fatalf("Wrong groups: %v", groups)
+	}
+	if eof := groups["EOF"]; len(eof) != 3 {
+		t.Errorf("Wrong EOF group: %v", eof)
+	}
+	loads := groups[errs[0].(*Error).StableFingerprint(0)]
+	if len(loads) != 3 || loads[0] != errs[0] || loads[1] != errs[4] || loads[2] != errs[8] {
+		t.Errorf("Wrong load group: %v", loads)
+	}
+}
+
+func TestTrimStack(t *testing.T) {
+	err := deepError(10)
+	depth := err.StackDepth()
+	frames := append([]StackFrame{}, err.StackFrames()...)
+
+	trimmed := err.TrimStack(2)
+	if !reflect.DeepEqual(trimmed.StackFrames(), frames[:2]) {
+		t.Errorf("Wrong frames: %#v", trimmed.StackFrames())
+	}
+	if !trimmed.Truncated() || !strings.Contains(trimmed.ErrorStack(), "stack truncated at 2 frames") {
+		t.Errorf("Trimmed stack not truncated:\n%s", trimmed.ErrorStack())
+	}
+	if err.StackDepth() != depth || !reflect.DeepEqual(err.StackFrames(), frames) {
+		t.Errorf("Original error modified")
+	}
+
+	if whole := err.TrimStack(len(frames)); !reflect.DeepEqual(whole.StackFrames(), frames) || whole.Truncated() != err.Truncated() {
+		t.Errorf("Short stack trimmed: %#v", whole.StackFrames())
+	}
+	if empty := err.TrimStack(-1); len(empty.StackFrames()) != 0 {
+		t.Errorf("Negative n kept frames: %#v", empty.StackFrames())
+	}
+}
+
+func TestStackTruncationNoDepth(t *testing.T) {
+	defer func(depth int, truncation Truncation) {
+		MaxStackDepth, StackTruncation = depth, truncation
+	}(MaxStackDepth, StackTruncation)
+
+	MaxStackDepth = 0
+	for _, truncation := range []Truncation{KeepLeaf, KeepRoot} {
+		StackTruncation = truncation
+		if err := New("foo").(*Error); err.StackDepth() != 0 {
+			t.Errorf("Stack captured with no depth: %d frames", err.StackDepth())
+		}
+	}
+}
+
+func TestTrimStackText(t *testing.T) {
+	text := "goroutine 7 [running]:\n" +
+		"main.run()\n" +
+		"\t/src/app/main.go:42 +0x1d\n" +
+		"main.main()\n" +
+		"\t/src/app/main.go:10 +0x25\n"
+
+	trimmed := NewFromStackText("stalled", text).TrimStack(1)
+	if errorStack := trimmed.ErrorStack(); strings.Contains(errorStack, "main.go:10") || !strings.Contains(errorStack, "main.go:42") {
+		t.Errorf("Stack text not trimmed:\n%s", errorStack)
+	}
+}
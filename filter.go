@@ -0,0 +1,216 @@
+package errors
+
+import (
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// AppFramesOnly restricts the frames returned by StackFrames, and so those
+// shown by Stack and ErrorStack, to functions in the main module and package
+// main. The main module's path is read once using runtime/debug.ReadBuildInfo;
+// if build information is unavailable no frames are removed. The default is
+// false.
+var AppFramesOnly = false
+
+// SkipStdlibFrames removes frames of standard library packages from those
+// returned by StackFrames. A package is considered part of the standard
+// library if the first element of its import path contains no dot, e.g.
+// "net/http" but not "github.com/go-errors/errors". Package main is kept.
+// Modules whose path has no dot in its first element are treated as standard
+// library by this heuristic. The default is false.
+var SkipStdlibFrames = false
+
+var mainModule struct {
+	once sync.Once
+	path string
+}
+
+// mainModulePath returns the path of the main module, or "" if unknown.
+func mainModulePath() string {
+	mainModule.once.Do(func() {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			mainModule.path = info.Main.Path
+		}
+	})
+	return mainModule.path
+}
+
+// A FrameFilter reports whether a frame should be kept.
+type FrameFilter func(frame StackFrame) bool
+
+// WithIncludePackages returns a FrameFilter keeping only frames whose package
+// starts with any of the prefixes, e.g. "github.com/acme/". With no prefixes
+// every frame is kept. Add it to Filters to apply it to StackFrames.
+func WithIncludePackages(prefixes ...string) FrameFilter {
+	return func(frame StackFrame) bool {
+		if len(prefixes) == 0 {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(frame.Package, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// A FilterChain is an ordered list of named FrameFilters, applied in the order
+// they were added. A frame is kept only if every filter keeps it. It is safe
+// for concurrent use.
+type FilterChain struct {
+	mu      sync.RWMutex
+	names   []string
+	filters []FrameFilter
+	version uint64
+}
+
+// Filters is the FilterChain applied to the frames returned by StackFrames,
+// after AppFramesOnly and SkipStdlibFrames. The filtered frames are cached on
+// each Error until the options or the chain change.
+var Filters = &FilterChain{}
+
+// Add appends filter to the chain under name, replacing any filter already
+// added with that name in its existing position.
+func (c *FilterChain) Add(name string, filter FrameFilter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	for i, n := range c.names {
+		if n == name {
+			c.filters[i] = filter
+			return
+		}
+	}
+	c.names = append(c.names, name)
+	c.filters = append(c.filters, filter)
+}
+
+// Remove removes the filter added under name, if any.
+func (c *FilterChain) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, n := range c.names {
+		if n == name {
+			c.version++
+			c.names = append(c.names[:i:i], c.names[i+1:]...)
+			c.filters = append(c.filters[:i:i], c.filters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Clear removes every filter from the chain.
+func (c *FilterChain) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	c.names = nil
+	c.filters = nil
+}
+
+// Apply returns the frames kept by every filter in the chain. frames is not
+// modified.
+func (c *FilterChain) Apply(frames []StackFrame) []StackFrame {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.filters) == 0 {
+		return frames
+	}
+
+	filtered := make([]StackFrame, 0, len(frames))
+	for _, frame := range frames {
+		if c.keep(frame) {
+			filtered = append(filtered, frame)
+		}
+	}
+	return filtered
+}
+
+func (c *FilterChain) keep(frame StackFrame) bool {
+	for _, filter := range c.filters {
+		if !filter(frame) {
+			return false
+		}
+	}
+	return true
+}
+
+// state returns the chain's version and whether it has any filters.
+func (c *FilterChain) state() (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.version, len(c.filters) > 0
+}
+
+// filterState captures the package level filtering options. It is comparable
+// so that it can identify the options used to filter cached frames.
+type filterState struct {
+	module     string
+	skipStdlib bool
+	chain      uint64
+	hasChain   bool
+}
+
+// currentFilterState returns the current package level filtering options.
+func currentFilterState() filterState {
+	state := filterState{skipStdlib: SkipStdlibFrames}
+	if AppFramesOnly {
+		state.module = mainModulePath()
+	}
+	state.chain, state.hasChain = Filters.state()
+	return state
+}
+
+// none reports whether no filtering is required.
+func (s filterState) none() bool {
+	return s.module == "" && !s.skipStdlib && !s.hasChain
+}
+
+// filterFrames returns the frames that should be shown according to the
+// package level filtering options. frames is not modified.
+func filterFrames(frames []StackFrame) []StackFrame {
+	return currentFilterState().apply(frames)
+}
+
+func (s filterState) apply(frames []StackFrame) []StackFrame {
+	if s.none() {
+		return frames
+	}
+
+	filtered := make([]StackFrame, 0, len(frames))
+	for _, frame := range frames {
+		if s.module != "" && !inModule(frame.Package, s.module) {
+			continue
+		}
+		if s.skipStdlib && isStdlib(frame.Package) {
+			continue
+		}
+		filtered = append(filtered, frame)
+	}
+	return Filters.Apply(filtered)
+}
+
+// inModule reports whether pkg is package main or belongs to module.
+func inModule(pkg, module string) bool {
+	return pkg == "main" || pkg == module || strings.HasPrefix(pkg, module+"/")
+}
+
+// isStdlib reports whether pkg appears to be a standard library package, see
+// SkipStdlibFrames.
+func isStdlib(pkg string) bool {
+	if pkg == "main" || pkg == "" {
+		return false
+	}
+	first := pkg
+	if i := strings.Index(pkg, "/"); i >= 0 {
+		first = pkg[:i]
+	}
+	return !strings.Contains(first, ".")
+}
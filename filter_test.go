@@ -0,0 +1,134 @@
+package errors
+
+import (
+	"io"
+	"testing"
+)
+
+func TestAppFramesOnly(t *testing.T) {
+	defer func(appFramesOnly bool) { AppFramesOnly = appFramesOnly }(AppFramesOnly)
+
+	err := New("foo").(*Error)
+	all := err.StackFrames()
+
+	AppFramesOnly = true
+	frames := err.StackFrames()
+	AppFramesOnly = false
+
+	if len(frames) == 0 || len(frames) >= len(all) {
+		t.Fatalf("Wrong number of frames: %d of %d", len(frames), len(all))
+	}
+	for _, frame := range frames {
+		if frame.Package != "github.com/go-errors/errors" {
+			t.Errorf("Frame outside of main module: %#v", frame)
+		}
+	}
+
+	if len(err.StackFrames()) != len(all) {
+		t.Errorf("Filtering modified the stored frames")
+	}
+}
+
+func TestSkipStdlibFrames(t *testing.T) {
+	defer func(skip bool) { SkipStdlibFrames = skip }(SkipStdlibFrames)
+
+	err := &Error{Err: io.EOF, frames: []StackFrame{
+		{Package: "github.com/acme/app", Name: "Handle"},
+		{Package: "net/http", Name: "HandlerFunc.ServeHTTP"},
+		{Package: "runtime", Name: "goexit"},
+		{Package: "main", Name: "main"},
+	}}
+
+	SkipStdlibFrames = true
+	frames := err.StackFrames()
+	if len(frames) != 2 || frames[0].Name != "Handle" || frames[1].Name != "main" {
+		t.Errorf("Wrong frames: %#v", frames)
+	}
+
+	SkipStdlibFrames = false
+	if len(err.StackFrames()) != 4 {
+		t.Errorf("Frames were filtered")
+	}
+}
+
+func TestFilterChain(t *testing.T) {
+	defer Filters.Clear()
+
+	err := &Error{Err: io.EOF, frames: []StackFrame{
+		{Package: "github.com/acme/app", Name: "Handle"},
+		{Package: "github.com/acme/app", Name: "serve"},
+		{Package: "github.com/acme/lib", Name: "Run"},
+	}}
+
+	Filters.Add("exported", func(frame StackFrame) bool { return frame.IsExported() })
+	Filters.Add("app", func(frame StackFrame) bool { return frame.Package == "github.com/acme/app" })
+
+	frames := err.StackFrames()
+	if len(frames) != 1 || frames[0].Name != "Handle" {
+		t.Errorf("Wrong frames: %#v", frames)
+	}
+	if cached := err.StackFrames(); &cached[0] != &frames[0] {
+		t.Errorf("Filtered frames not cached")
+	}
+
+	Filters.Remove("app")
+	if frames := err.StackFrames(); len(frames) != 2 {
+		t.Errorf("Filter not removed: %#v", frames)
+	}
+
+	Filters.Add("exported", func(frame StackFrame) bool { return frame.Name != "Run" })
+	if frames := err.StackFrames(); len(frames) != 2 || frames[1].Name != "serve" {
+		t.Errorf("Filter not replaced: %#v", frames)
+	}
+
+	Filters.Clear()
+	if frames := err.StackFrames(); len(frames) != 3 {
+		t.Errorf("Filters not cleared: %#v", frames)
+	}
+}
+
+func TestWithIncludePackages(t *testing.T) {
+	defer Filters.Clear()
+
+	err := &Error{Err: io.EOF, frames: []StackFrame{
+		{Package: "github.com/acme/app", Name: "Handle"},
+		{Package: "net/http", Name: "serve"},
+		{Package: "github.com/other/lib", Name: "Run"},
+		{Package: "main", Name: "main"},
+	}}
+
+	Filters.Add("include", WithIncludePackages("github.com/acme/", "main"))
+	if frames := err.StackFrames(); len(frames) != 2 || frames[0].Name != "Handle" || frames[1].Name != "main" {
+		t.Errorf("Wrong frames: %#v", frames)
+	}
+
+	Filters.Add("include", WithIncludePackages())
+	if frames := err.StackFrames(); len(frames) != 4 {
+		t.Errorf("Empty list filtered frames: %#v", frames)
+	}
+}
+
+func TestWrapFiltered(t *testing.T) {
+	if WrapFiltered(nil, 0, nil) != nil {
+		t.Errorf("Nil error wrapped")
+	}
+
+	testOnly := func(frame StackFrame) bool { return frame.Package != "testing" && frame.Package != "runtime" }
+	err := WrapFiltered(io.EOF, 0, testOnly).(*Error)
+
+	frames := err.StackFrames()
+	if len(frames) != 1 || frames[0].Name != "TestWrapFiltered" {
+		t.Errorf("Wrong frames: %#v", frames)
+	}
+	if len(err.Callers()) <= len(frames) {
+		t.Errorf("Program counters filtered: %d", len(err.Callers()))
+	}
+	if data, _ := io.ReadAll(err.StackReader()); string(data) != string(err.Stack()) {
+		t.Errorf("Wrong stack reader output:\n%s", data)
+	}
+
+	plain := New(io.EOF).(*Error)
+	if filtered := WrapFiltered(plain, 0, testOnly).(*Error); len(filtered.StackFrames()) != 1 || len(plain.StackFrames()) == 1 {
+		t.Errorf("Filter not applied to a copy")
+	}
+}
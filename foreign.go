@@ -0,0 +1,96 @@
+package errors
+
+import "reflect"
+
+// A StackExtractor returns the program counters of a stack carried by err
+// itself, not by the errors it wraps, if it has one.
+type StackExtractor func(err error) ([]uintptr, bool)
+
+// StackExtractors recognise errors from other packages that carry a
+// stacktrace. By default they recognise errors with a Callers() []uintptr
+// method, and errors with a StackTrace method returning a slice of program
+// counters, such as those of github.com/pkg/errors. Append to it to recognise
+// other types.
+var StackExtractors = []StackExtractor{callersStack, stackTraceStack}
+
+func callersStack(err error) ([]uintptr, bool) {
+	e, ok := err.(interface{ Callers() []uintptr })
+	if !ok {
+		return nil, false
+	}
+	return e.Callers(), true
+}
+
+func stackTraceStack(err error) ([]uintptr, bool) {
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() {
+		return nil, false
+	}
+	t := method.Type()
+	if t.NumIn() != 0 || t.NumOut() != 1 || t.Out(0).Kind() != reflect.Slice || t.Out(0).Elem().Kind() != reflect.Uintptr {
+		return nil, false
+	}
+
+	trace := method.Call(nil)[0]
+	pcs := make([]uintptr, trace.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(trace.Index(i).Uint())
+	}
+	return pcs, true
+}
+
+// foreignStack returns the stack carried by err if it is not an *Error and is
+// recognised by StackExtractors.
+func foreignStack(err error) ([]uintptr, bool) {
+	if _, ok := err.(*Error); ok {
+		return nil, false
+	}
+	for _, extract := range StackExtractors {
+		if pcs, ok := extract(err); ok {
+			return pcs, true
+		}
+	}
+	return nil, false
+}
+
+// HasForeignStack reports whether any error in err's chain, other than an
+// *Error, carries a stacktrace recognised by StackExtractors. Middleware can
+// use it to avoid adding a redundant stack to errors traced by another
+// package.
+func HasForeignStack(err error) bool {
+	return !walk(err, func(e error) bool {
+		_, ok := foreignStack(e)
+		return !ok
+	})
+}
+
+// Adopt returns err as an *Error. If err is already an *Error it is returned
+// unchanged. Otherwise, if an error in its chain carries a stacktrace
+// recognised by StackExtractors, the first such stack is reused, keeping the
+// original point of capture. If there is none a new stacktrace is captured,
+// pointing to the line of code that called Adopt. If err is nil, nil is
+// returned.
+func Adopt(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+
+	var pcs []uintptr
+	found := !walk(err, func(e error) bool {
+		var ok bool
+		pcs, ok = foreignStack(e)
+		return !ok
+	})
+	if !found {
+		return wrap(err, 0)
+	}
+
+	return &Error{
+		Err:     err,
+		stack:   pcs,
+		created: now(),
+	}
+}
@@ -0,0 +1,89 @@
+package errors
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// frame and stackTrace mirror the types of github.com/pkg/errors.
+type frame uintptr
+
+type stackTrace []frame
+
+type tracedError struct {
+	stack []uintptr
+}
+
+func (e *tracedError) Error() string {
+	return "traced"
+}
+
+func (e *tracedError) StackTrace() stackTrace {
+	trace := make(stackTrace, len(e.stack))
+	for i, pc := range e.stack {
+		trace[i] = frame(pc)
+	}
+	return trace
+}
+
+func newTracedError() *tracedError {
+	stack := make([]uintptr, MaxStackDepth)
+	return &tracedError{stack: stack[:runtime.Callers(2, stack)]}
+}
+
+type callersError struct{}
+
+func (callersError) Error() string {
+	return "callers"
+}
+
+func (callersError) Callers() []uintptr {
+	return []uintptr{1}
+}
+
+func TestHasForeignStack(t *testing.T) {
+	if HasForeignStack(New(io.EOF)) || HasForeignStack(io.EOF) || HasForeignStack(nil) {
+		t.Errorf("Foreign stack found without one")
+	}
+	if !HasForeignStack(Wrap(fmt.Errorf("wrapped: %w", newTracedError()), 0)) {
+		t.Errorf("StackTrace not detected")
+	}
+	if !HasForeignStack(fmt.Errorf("wrapped: %w", callersError{})) {
+		t.Errorf("Callers not detected")
+	}
+
+	defer func(extractors []StackExtractor) { StackExtractors = extractors }(StackExtractors)
+	StackExtractors = append(StackExtractors, func(err error) ([]uintptr, bool) {
+		return nil, err == io.EOF
+	})
+	if !HasForeignStack(fmt.Errorf("wrapped: %w", io.EOF)) {
+		t.Errorf("Custom extractor not used")
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	if Adopt(nil) != nil {
+		t.Errorf("Nil error adopted")
+	}
+	if err := New(io.EOF).(*Error); Adopt(err) != err {
+		t.Errorf("*Error adopted")
+	}
+
+	traced, expected := newTracedError(), callers()
+	err := Adopt(fmt.Errorf("wrapped: %w", traced))
+	if err := compareStacks(err.stack, expected); err != nil {
+		t.Errorf("Foreign stack not reused")
+		t.Errorf(err.Error())
+	}
+	if err.Error() != "wrapped: traced" {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+
+	err, expected = Adopt(io.EOF), callers()
+	if err := compareStacks(err.stack, expected); err != nil {
+		t.Errorf("New stack not captured")
+		t.Errorf(err.Error())
+	}
+}
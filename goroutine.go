@@ -0,0 +1,104 @@
+package errors
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// CaptureGoroutineID records, for each Error made by New or Wrap, the id of the
+// goroutine in which it was made, as used by LeakedGoroutines. The default is
+// false, as the id can only be found by formatting the goroutine's stack with
+// runtime.Stack.
+var CaptureGoroutineID = false
+
+// goroutineStacks returns the output of runtime.Stack for the current
+// goroutine, or for every goroutine if all is set.
+func goroutineStacks(all bool) []byte {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, all)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// goroutineID returns the id of the current goroutine if CaptureGoroutineID is
+// set.
+func goroutineID() uint64 {
+	if !CaptureGoroutineID {
+		return 0
+	}
+	id, _ := parseGoroutineHeader(goroutineStacks(false))
+	return id
+}
+
+// parseGoroutineHeader parses the id from the first line of a goroutine's
+// stack, e.g. "goroutine 7 [running]:", reporting whether it was found.
+func parseGoroutineHeader(stack []byte) (uint64, bool) {
+	if !bytes.HasPrefix(stack, []byte("goroutine ")) {
+		return 0, false
+	}
+	stack = stack[len("goroutine "):]
+	if end := bytes.IndexByte(stack, ' '); end >= 0 {
+		stack = stack[:end]
+	}
+	id, err := strconv.ParseUint(string(stack), 10, 64)
+	return id, err == nil
+}
+
+// GoroutineID returns the id of the goroutine in which the error was made, if
+// CaptureGoroutineID was set, or 0 otherwise.
+func (err *Error) GoroutineID() uint64 {
+	return err.gid
+}
+
+// A GoroutineLeak is a goroutine that made errors and was still running when
+// checked by LeakedGoroutines.
+type GoroutineLeak struct {
+	// ID is the id of the goroutine.
+	ID uint64
+	// Errors are the errors made in the goroutine.
+	Errors []*Error
+	// Stack is the current stack of the goroutine, as given by runtime.Stack.
+	Stack string
+}
+
+// LeakedGoroutines returns the goroutines, in order of id, that made any of
+// the *Errors in the chains of errs and are still running. It is intended for
+// test teardown, to find goroutines that failed without exiting. Only errors
+// made while CaptureGoroutineID was set are considered. The calling goroutine
+// is never reported.
+func LeakedGoroutines(errs ...error) []GoroutineLeak {
+	byID := map[uint64][]*Error{}
+	for _, err := range errs {
+		walk(err, func(e error) bool {
+			if e, ok := e.(*Error); ok && e.gid != 0 {
+				byID[e.gid] = append(byID[e.gid], e)
+			}
+			return true
+		})
+	}
+	if len(byID) == 0 {
+		return nil
+	}
+
+	var leaks []GoroutineLeak
+	stacks := bytes.Split(goroutineStacks(true), []byte("\n\n"))
+	for i, stack := range stacks {
+		id, ok := parseGoroutineHeader(stack)
+		// the first stack is that of the calling goroutine.
+		if !ok || i == 0 || byID[id] == nil {
+			continue
+		}
+		leaks = append(leaks, GoroutineLeak{ID: id, Errors: byID[id], Stack: string(bytes.TrimSpace(stack)) + "\n"})
+	}
+
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].ID < leaks[j].ID
+	})
+	return leaks
+}
@@ -0,0 +1,53 @@
+package errors
+
+import (
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLeakedGoroutines(t *testing.T) {
+	defer func(capture bool) { CaptureGoroutineID = capture }(CaptureGoroutineID)
+
+	if New(io.EOF).(*Error).GoroutineID() != 0 {
+		t.Errorf("Goroutine id captured by default")
+	}
+	CaptureGoroutineID = true
+
+	errs := make(chan error)
+	release := make(chan struct{})
+	defer close(release)
+
+	go func() {
+		errs <- New("finished")
+	}()
+	finished := <-errs
+
+	go func() {
+		errs <- New("stuck")
+		<-release
+	}()
+	stuck := <-errs
+
+	if stuck.(*Error).GoroutineID() == 0 || stuck.(*Error).GoroutineID() == finished.(*Error).GoroutineID() {
+		t.Errorf("Wrong goroutine ids")
+	}
+
+	leaks := LeakedGoroutines(finished, Wrap(stuck, 0), New("local"))
+	for len(leaks) > 0 && leaks[0].ID == finished.(*Error).GoroutineID() {
+		// the first goroutine has yet to exit.
+		runtime.Gosched()
+		leaks = LeakedGoroutines(finished, Wrap(stuck, 0), New("local"))
+	}
+	if len(leaks) != 1 || leaks[0].ID != stuck.(*Error).GoroutineID() || leaks[0].Errors[0] != stuck {
+		t.Fatalf("Wrong leaks: %#v", leaks)
+	}
+	if !strings.Contains(leaks[0].Stack, "TestLeakedGoroutines") {
+		t.Errorf("Wrong stack: %s", leaks[0].Stack)
+	}
+
+	if LeakedGoroutines(io.EOF) != nil {
+		t.Errorf("Leak reported without errors")
+	}
+}
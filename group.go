@@ -0,0 +1,132 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// A Group is a collection of goroutines working on subtasks of a common task,
+// with the same API as golang.org/x/sync/errgroup. The first error returned by
+// a goroutine is wrapped with a stacktrace pointing to the line of code that
+// called Go or TryGo for it, unless it is already an *Error.
+//
+// A zero Group is valid, has no limit on the number of active goroutines, and
+// does not cancel on error.
+type Group struct {
+	cancel func()
+
+	wg sync.WaitGroup
+
+	sem chan token
+
+	errOnce sync.Once
+	err     error
+}
+
+type token struct{}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
+
+// WithContext returns a new Group and an associated Context derived from ctx.
+// The derived Context is canceled the first time a function passed to Go
+// returns a non-nil error or the first time Wait returns, whichever occurs
+// first.
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// Go calls the given function in a new goroutine. It blocks until the new
+// goroutine can be added without the number of active goroutines in the group
+// exceeding the configured limit. The first call to return a non-nil error
+// cancels the group's context, if any; its error will be returned by Wait.
+func (g *Group) Go(f func() error) {
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2, stack[:])
+
+	if g.sem != nil {
+		g.sem <- token{}
+	}
+	g.run(f, stack, length)
+}
+
+// TryGo calls the given function in a new goroutine only if the number of
+// active goroutines in the group is currently below the configured limit. The
+// return value reports whether the goroutine was started.
+func (g *Group) TryGo(f func() error) bool {
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2, stack[:])
+
+	if g.sem != nil {
+		select {
+		case g.sem <- token{}:
+		default:
+			return false
+		}
+	}
+	g.run(f, stack, length)
+	return true
+}
+
+// run calls f in a new goroutine, recording its error with the stack captured
+// by Go or TryGo.
+func (g *Group) run(f func() error, stack []uintptr, length int) {
+	created := now()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.done()
+
+		if err := f(); err != nil {
+			g.errOnce.Do(func() {
+				if _, ok := err.(*Error); !ok {
+					err = &Error{
+						Err:      err,
+						stack:    stack[:length],
+						maxDepth: len(stack),
+						created:  created,
+					}
+				}
+				g.err = err
+				if g.cancel != nil {
+					g.cancel()
+				}
+			})
+		}
+	}()
+}
+
+// Wait blocks until all function calls from the Go method have returned, then
+// returns the first non-nil error (if any) from them.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	return g.err
+}
+
+// SetLimit limits the number of active goroutines in this group to at most n.
+// A negative value indicates no limit. A limit of zero will prevent any new
+// goroutines from being added.
+//
+// Any subsequent call to the Go method will block until it can add an active
+// goroutine without exceeding the configured limit.
+//
+// The limit must not be modified while any goroutines in the group are active.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("errors: modify limit while %v goroutines in the group are still active", len(g.sem)))
+	}
+	g.sem = make(chan token, n)
+}
@@ -0,0 +1,76 @@
+package errors
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	var g Group
+	g.Go(func() error { return nil })
+	if err := g.Wait(); err != nil {
+		t.Errorf("Wait returned an error: %v", err)
+	}
+
+	group, ctx := WithContext(context.Background())
+	group.Go(func() error { return nil })
+	expected := callers()
+	group.Go(func() error { return io.EOF })
+
+	err, ok := group.Wait().(*Error)
+	if !ok || err.Err != io.EOF {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	if err := compareStacks(err.stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+	if ctx.Err() == nil {
+		t.Errorf("Context not canceled")
+	}
+
+	original := New(io.EOF)
+	g = Group{}
+	g.Go(func() error { return original })
+	if g.Wait() != original {
+		t.Errorf("*Error was wrapped again")
+	}
+}
+
+func TestGroupLimit(t *testing.T) {
+	var g Group
+	g.SetLimit(1)
+
+	release := make(chan struct{})
+	g.Go(func() error {
+		<-release
+		return nil
+	})
+	if g.TryGo(func() error { return nil }) {
+		t.Errorf("TryGo started a goroutine beyond the limit")
+	}
+	close(release)
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Wait returned an error: %v", err)
+	}
+
+	started, expected := g.TryGo(func() error { return io.EOF }), callers()
+	if !started {
+		t.Fatalf("TryGo did not start a goroutine within the limit")
+	}
+	err, ok := g.Wait().(*Error)
+	if !ok || err.Err != io.EOF {
+		t.Fatalf("Wrong error: %v", err)
+	}
+	if err := compareStacks(err.stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	g.SetLimit(-1)
+	if !g.TryGo(func() error { return nil }) {
+		t.Errorf("TryGo limited without a limit")
+	}
+	g.Wait()
+}
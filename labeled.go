@@ -0,0 +1,138 @@
+package errors
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// labeledStack is an additional stack recorded by AddStack.
+type labeledStack struct {
+	label  string
+	stack  []uintptr
+	frames []StackFrame
+	at     time.Time
+}
+
+// StackFrames returns the resolved frames of the labeled stack.
+func (s *labeledStack) StackFrames() []StackFrame {
+	if s.frames == nil {
+		s.frames = resolveFrames(s.stack)
+	}
+
+	return filterFrames(s.frames)
+}
+
+// AddStack returns a copy of err with an additional stacktrace, pointing to
+// the line of code that called AddStack, recorded under label. This records
+// the stages an error passes through, e.g. "enqueued" and "dequeued". The
+// stack captured when the error was created remains the primary stack. If
+// CaptureTimestamps is set the time of each stage is also recorded, see
+// StageDurations.
+func (err *Error) AddStack(label string) *Error {
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2, stack[:])
+	return err.withStack(label, stack[:length])
+}
+
+// appendedLabel is the label of the stacks added by AppendStack.
+const appendedLabel = "appended"
+
+// AppendStack returns a copy of err with the current stacktrace appended to
+// the journey recorded in ErrorStack, below the original stack and any others
+// added, under the heading "appended:". Further stacks appended are numbered,
+// "appended#2:" and so on, so that each has its own entry in LabeledStacks and
+// StageDurations. The original stack, and so
+// StackFrames, is kept intact. The skip parameter indicates how far up the
+// stack to start the stacktrace. 0 is from the current call, 1 from its
+// caller, etc. If err is nil, nil is returned.
+func AppendStack(err *Error, skip int) *Error {
+	if err == nil {
+		return nil
+	}
+
+	stack := make([]uintptr, MaxStackDepth)
+	length := captureCallers(2+skip, stack[:])
+	return err.withStack(err.nextAppendedLabel(), stack[:length])
+}
+
+// nextAppendedLabel returns the label for the next stack added by AppendStack.
+func (err *Error) nextAppendedLabel() string {
+	n := 1
+	for _, s := range err.labeled {
+		if s.label == appendedLabel || strings.HasPrefix(s.label, appendedLabel+"#") {
+			n++
+		}
+	}
+
+	if n == 1 {
+		return appendedLabel
+	}
+	return fmt.Sprintf("%s#%d", appendedLabel, n)
+}
+
+// withStack returns a copy of err with an additional stack recorded under
+// label.
+func (err *Error) withStack(label string, stack []uintptr) *Error {
+	copied := *err
+	copied.labeled = make([]labeledStack, len(err.labeled), len(err.labeled)+1)
+	copy(copied.labeled, err.labeled)
+	copied.labeled = append(copied.labeled, labeledStack{label: label, stack: stack, at: now()})
+	return &copied
+}
+
+// LabeledStacks returns the frames of each stack recorded by AddStack, keyed
+// by label.
+func (err *Error) LabeledStacks() map[string][]StackFrame {
+	stacks := make(map[string][]StackFrame, len(err.labeled))
+	for i := range err.labeled {
+		stacks[err.labeled[i].label] = err.labeled[i].StackFrames()
+	}
+	return stacks
+}
+
+// StageDurations returns, keyed by label, the time elapsed between each stack
+// recorded by AddStack and the previous stage, the first being measured from
+// the creation of the error. Stages are only timed if CaptureTimestamps was
+// set both when the error was created and when they were added.
+func (err *Error) StageDurations() map[string]time.Duration {
+	durations := map[string]time.Duration{}
+
+	for i, s := range err.labeled {
+		if previous := err.stageStart(i); !previous.IsZero() && !s.at.IsZero() {
+			durations[s.label] = s.at.Sub(previous)
+		}
+	}
+	return durations
+}
+
+// labeledStacksString formats the stacks recorded by AddStack for ErrorStack,
+// in the order they were added, with the time elapsed since the previous stage
+// if known.
+func (err *Error) labeledStacksString() string {
+	buf := bytes.Buffer{}
+
+	for i := range err.labeled {
+		s := &err.labeled[i]
+		buf.WriteString("\n" + s.label)
+		if previous := err.stageStart(i); !previous.IsZero() && !s.at.IsZero() {
+			fmt.Fprintf(&buf, " (+%dms)", s.at.Sub(previous).Milliseconds())
+		}
+		buf.WriteString(":\n")
+		for _, frame := range s.StackFrames() {
+			buf.WriteString(frame.String())
+		}
+	}
+
+	return buf.String()
+}
+
+// stageStart returns the time at which the stage before the i'th labeled stack
+// was recorded.
+func (err *Error) stageStart(i int) time.Time {
+	if i == 0 {
+		return err.created
+	}
+	return err.labeled[i-1].at
+}
@@ -0,0 +1,107 @@
+package errors
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddStack(t *testing.T) {
+	original := New(io.EOF).(*Error)
+
+	enqueued, expected := original.AddStack("enqueued"), callers()
+	dequeued := enqueued.AddStack("dequeued")
+
+	if len(original.LabeledStacks()) != 0 || len(enqueued.LabeledStacks()) != 1 {
+		t.Errorf("AddStack modified the original error")
+	}
+
+	stacks := dequeued.LabeledStacks()
+	if len(stacks) != 2 || stacks["enqueued"] == nil || stacks["dequeued"] == nil {
+		t.Fatalf("Wrong labeled stacks: %v", stacks)
+	}
+
+	if err := compareStacks(enqueued.labeled[0].stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	if !reflect.DeepEqual(dequeued.StackFrames(), original.StackFrames()) {
+		t.Errorf("Primary stack changed")
+	}
+
+	errorStack := dequeued.ErrorStack()
+	enqueuedAt := strings.Index(errorStack, "\nenqueued:\n")
+	dequeuedAt := strings.Index(errorStack, "\ndequeued:\n")
+	if enqueuedAt < 0 || dequeuedAt < enqueuedAt {
+		t.Errorf("Labeled stacks not shown in order:\n%s", errorStack)
+	}
+}
+
+func TestAppendStack(t *testing.T) {
+	if AppendStack(nil, 0) != nil {
+		t.Errorf("Nil error appended to")
+	}
+
+	original := New(io.EOF).(*Error)
+	appended, expected := AppendStack(original, 0), callers()
+	twice := AppendStack(appended, 0)
+
+	if err := compareStacks(appended.labeled[0].stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+	if !reflect.DeepEqual(twice.StackFrames(), original.StackFrames()) || len(original.labeled) != 0 {
+		t.Errorf("Original stack changed")
+	}
+
+	errorStack := twice.ErrorStack()
+	if !strings.HasPrefix(errorStack, original.ErrorStack()) || !strings.Contains(errorStack, "\nappended:\n") || !strings.Contains(errorStack, "\nappended#2:\n") {
+		t.Errorf("Appended stacks not shown:\n%s", errorStack)
+	}
+	if stacks := twice.LabeledStacks(); len(stacks) != 2 || stacks["appended"] == nil || stacks["appended#2"] == nil {
+		t.Errorf("Appended stacks not all labeled: %v", stacks)
+	}
+}
+
+func TestStageDurations(t *testing.T) {
+	defer func(capture bool) { CaptureTimestamps = capture }(CaptureTimestamps)
+
+	if durations := New(io.EOF).(*Error).AddStack("enqueued").StageDurations(); len(durations) != 0 {
+		t.Errorf("Stages timed without timestamps: %v", durations)
+	}
+
+	CaptureTimestamps = true
+	err := New(io.EOF).(*Error)
+	err.created = err.created.Add(-time.Second)
+	enqueued := err.AddStack("enqueued")
+	enqueued.labeled[0].at = enqueued.labeled[0].at.Add(-500 * time.Millisecond)
+	dequeued := enqueued.AddStack("dequeued")
+
+	durations := dequeued.StageDurations()
+	if d := durations["enqueued"]; d < 500*time.Millisecond || d > time.Second {
+		t.Errorf("Wrong enqueued duration: %v", d)
+	}
+	if d := durations["dequeued"]; d < 500*time.Millisecond || d > time.Second {
+		t.Errorf("Wrong dequeued duration: %v", d)
+	}
+	if errorStack := dequeued.ErrorStack(); !strings.Contains(errorStack, "\nenqueued (+") || !strings.Contains(errorStack, "\ndequeued (+") {
+		t.Errorf("Timings not shown:\n%s", errorStack)
+	}
+
+	data, e := dequeued.MarshalBinary()
+	if e != nil {
+		t.Fatal(e)
+	}
+	var decoded Error
+	if e := decoded.UnmarshalBinary(data); e != nil {
+		t.Fatal(e)
+	}
+	for label, d := range decoded.StageDurations() {
+		if d.Milliseconds() != durations[label].Milliseconds() {
+			t.Errorf("Timing of %s not preserved: %v", label, d)
+		}
+	}
+}
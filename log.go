@@ -0,0 +1,31 @@
+package errors
+
+// Logger receives the errors logged by WrapLog. It is a single method so that
+// any logging library can be adapted to it without this package depending on
+// one.
+type Logger interface {
+	Log(level Severity, msg string)
+}
+
+// ErrorLogger, if set, is the Logger that WrapLog logs to. The default is nil,
+// so WrapLog only wraps.
+var ErrorLogger Logger
+
+// LogLevel is the level at which WrapLog logs. The default is SeverityError.
+var LogLevel = SeverityError
+
+// WrapLog wraps e in the same way as Wrap and, if ErrorLogger is set, logs
+// the ErrorStack of the result at LogLevel before returning it. This replaces
+// the common pattern of wrapping an error and logging it at the same site. If
+// e is nil, nil is returned and nothing is logged.
+func WrapLog(e interface{}, skip int) error {
+	if e == nil {
+		return nil
+	}
+
+	err := wrap(e, skip)
+	if ErrorLogger != nil {
+		ErrorLogger.Log(LogLevel, err.ErrorStack())
+	}
+	return err
+}
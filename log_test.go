@@ -0,0 +1,40 @@
+package errors
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+type testLogger struct {
+	levels   []Severity
+	messages []string
+}
+
+func (l *testLogger) Log(level Severity, msg string) {
+	l.levels = append(l.levels, level)
+	l.messages = append(l.messages, msg)
+}
+
+func TestWrapLog(t *testing.T) {
+	defer func(logger Logger, level Severity) { ErrorLogger, LogLevel = logger, level }(ErrorLogger, LogLevel)
+
+	if WrapLog(nil, 0) != nil {
+		t.Errorf("nil not returned for nil")
+	}
+	if err := WrapLog(io.EOF, 0).(*Error); err.StackFrames()[0].Name != "TestWrapLog" {
+		t.Errorf("Wrong stack: %s", err.ErrorStack())
+	}
+
+	logger := &testLogger{}
+	ErrorLogger, LogLevel = logger, SeverityWarning
+	WrapLog(nil, 0)
+	err := WrapLog(io.EOF, 0).(*Error)
+
+	if len(logger.messages) != 1 || logger.levels[0] != SeverityWarning || logger.messages[0] != err.ErrorStack() {
+		t.Fatalf("Wrong log: %v %q", logger.levels, logger.messages)
+	}
+	if !strings.Contains(logger.messages[0], "TestWrapLog") {
+		t.Errorf("Stack not logged: %s", logger.messages[0])
+	}
+}
@@ -0,0 +1,426 @@
+package errors
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+)
+
+// Metadata keys used by the annotations provided by this package.
+const (
+	envKey       = "env"
+	temporaryKey = "temporary"
+	retryableKey = "retryable"
+	codeKey      = "code"
+	snapshotKey  = "snapshot"
+	hopsKey      = "goroutine_hops"
+	loggedKey    = "logged"
+	userKey      = "user_message"
+	requestKey   = "request"
+	severityKey  = "severity"
+	queryKey     = "query"
+)
+
+// Metadata returns a copy of the annotations attached to the error, keyed by
+// name. These are included in MarshalJSON.
+func (err *Error) Metadata() map[string]interface{} {
+	metadata := make(map[string]interface{}, len(err.metadata))
+	for k, v := range err.metadata {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+// withMetadata returns a copy of err with key set to value. The original
+// error is not modified.
+func (err *Error) withMetadata(key string, value interface{}) *Error {
+	copied := *err
+	copied.metadata = err.Metadata()
+	copied.metadata[key] = value
+	return &copied
+}
+
+// Env is a snapshot of the runtime environment in which an error occurred.
+type Env struct {
+	GOOS      string `json:"goos"`
+	GOARCH    string `json:"goarch"`
+	GoVersion string `json:"go_version"`
+	Hostname  string `json:"hostname"`
+}
+
+// String returns the environment as a single line, as shown in ErrorStack.
+func (env Env) String() string {
+	return fmt.Sprintf("env: %s/%s %s on %s\n", env.GOOS, env.GOARCH, env.GoVersion, env.Hostname)
+}
+
+// NewWithEnv makes an Error in the same way as New, additionally recording a
+// snapshot of the runtime environment (GOOS, GOARCH, Go version and hostname)
+// in its metadata. The snapshot is shown in ErrorStack and included in
+// MarshalJSON. Looking up the hostname has a cost, so this should be reserved
+// for severe errors.
+func NewWithEnv(e interface{}) error {
+	hostname, _ := os.Hostname()
+
+	err := newSkip(e, 0)
+	err.metadata = map[string]interface{}{
+		envKey: Env{
+			GOOS:      runtime.GOOS,
+			GOARCH:    runtime.GOARCH,
+			GoVersion: runtime.Version(),
+			Hostname:  hostname,
+		},
+	}
+	return err
+}
+
+// WithTemporary returns a copy of err explicitly marked as temporary or
+// permanent. The flag takes precedence over any Temporary method of the errors
+// it wraps.
+func (err *Error) WithTemporary(temporary bool) *Error {
+	return err.withMetadata(temporaryKey, temporary)
+}
+
+// Temporary reports whether the error is temporary. See the package level
+// Temporary.
+func (err *Error) Temporary() bool {
+	return Temporary(err)
+}
+
+// Temporary reports whether err is temporary, and so may succeed if retried.
+// The first error in the chain that either has a flag set by WithTemporary or
+// implements the net.Error style interface { Temporary() bool } decides the
+// result. If there is no such error Temporary returns false.
+func Temporary(err error) bool {
+	temporary := false
+
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			v, ok := e.metadata[temporaryKey].(bool)
+			if ok {
+				temporary = v
+			}
+			return !ok
+		}
+		if e, ok := e.(interface{ Temporary() bool }); ok {
+			temporary = e.Temporary()
+			return false
+		}
+		return true
+	})
+
+	return temporary
+}
+
+// WithRetryable returns a copy of err explicitly marked as retryable or not.
+func (err *Error) WithRetryable(retryable bool) *Error {
+	return err.withMetadata(retryableKey, retryable)
+}
+
+// Retryable returns the flag set by WithRetryable on the first *Error in err's
+// chain that has one. The second result reports whether any flag was found.
+func Retryable(err error) (bool, bool) {
+	var retryable, found bool
+
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			retryable, found = e.metadata[retryableKey].(bool)
+		}
+		return !found
+	})
+
+	return retryable, found
+}
+
+// WrapCode wraps err with a code, a message prefix and a new stacktrace
+// pointing to the line of code that called WrapCode. The code is an HTTP status
+// code, e.g. 404, as used by ToProblemDetail; ToStatusDetails converts it to
+// the equivalent gRPC code. Unlike WrapPrefix, an *Error is wrapped again. If
+// err is nil, nil is returned.
+func WrapCode(err error, code int, message string) error {
+	if err == nil {
+		return nil
+	}
+
+	e := newSkip(err, 0)
+	e.prefix = message
+	e.metadata = map[string]interface{}{codeKey: code}
+	return e
+}
+
+// CodeOf returns the code attached by WrapCode to the first *Error in err's
+// chain that has one. The second result reports whether any code was found.
+func CodeOf(err error) (int, bool) {
+	var code int
+	var found bool
+
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			code, found = e.metadata[codeKey].(int)
+		}
+		return !found
+	})
+
+	return code, found
+}
+
+// BaseMessage returns the message of the wrapped error, without the prefix
+// added by WrapPrefix or WrapCode.
+func (err *Error) BaseMessage() string {
+	return err.Err.Error()
+}
+
+// WithSnapshot returns a copy of err recording the given values, typically the
+// arguments and local variables at the point the error occurred. The values
+// are shown in ErrorStack below the frame where the stack was captured, and
+// included in MarshalJSON.
+func (err *Error) WithSnapshot(values map[string]interface{}) *Error {
+	snapshot := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		snapshot[k] = v
+	}
+	return err.withMetadata(snapshotKey, snapshot)
+}
+
+// snapshotString formats the snapshot for ErrorStack, one value per line in
+// order of name.
+func (err *Error) snapshotString() string {
+	snapshot, ok := err.metadata[snapshotKey].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	str := ""
+	for _, name := range names {
+		str += fmt.Sprintf("\t\t%s = %#v\n", name, snapshot[name])
+	}
+	return str
+}
+
+// WrapAcross wraps e as Wrap does, additionally recording that the error has
+// crossed a goroutine boundary. It should be called by the goroutine receiving
+// an error from another, e.g. from a channel. If e is nil, nil is returned.
+func WrapAcross(e interface{}, skip int) error {
+	if e == nil {
+		return nil
+	}
+
+	err := wrap(e, skip)
+	hops := err.GoroutineHops()
+	if inner := firstError(err.Err); inner != nil && inner.GoroutineHops() > hops {
+		hops = inner.GoroutineHops()
+	}
+	return err.withMetadata(hopsKey, hops+1)
+}
+
+// GoroutineHops returns the number of goroutine boundaries the error has
+// crossed, as recorded by WrapAcross.
+func (err *Error) GoroutineHops() int {
+	hops, _ := err.metadata[hopsKey].(int)
+	return hops
+}
+
+// firstError returns the first *Error in err's chain, or nil if there is none.
+func firstError(err error) *Error {
+	var first *Error
+
+	walk(err, func(e error) bool {
+		first, _ = e.(*Error)
+		return first == nil
+	})
+
+	return first
+}
+
+// WithLogged returns a copy of err marked as already logged, so that logging
+// middleware further up the call stack can avoid logging it again.
+func (err *Error) WithLogged() *Error {
+	return err.withMetadata(loggedKey, true)
+}
+
+// AlreadyLogged reports whether any *Error in err's chain was marked by
+// WithLogged. As the mark is found through the chain, it is kept when the
+// error is wrapped again.
+func AlreadyLogged(err error) bool {
+	return !walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok && e.metadata[loggedKey] == true {
+			return false
+		}
+		return true
+	})
+}
+
+// WithUserMessage returns a copy of err carrying a message that is safe to show
+// to end users. Error() is unchanged and still returns the technical message.
+func (err *Error) WithUserMessage(message string) *Error {
+	return err.withMetadata(userKey, message)
+}
+
+// UserMessage returns the message attached by WithUserMessage to the first
+// *Error in err's chain that has one. The second result reports whether any
+// message was found.
+func UserMessage(err error) (string, bool) {
+	var message string
+	var found bool
+
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			message, found = e.metadata[userKey].(string)
+		}
+		return !found
+	})
+
+	return message, found
+}
+
+// Request describes the HTTP request that caused an error.
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+// WithRequest returns a copy of err recording the HTTP request that caused it
+// and the status code of the response. The request is included in
+// MarshalJSON.
+func (err *Error) WithRequest(method, path string, status int) *Error {
+	return err.withMetadata(requestKey, Request{Method: method, Path: path, Status: status})
+}
+
+// RequestInfo returns the request recorded by WithRequest. The second result
+// reports whether one was recorded.
+func (err *Error) RequestInfo() (Request, bool) {
+	request, ok := err.metadata[requestKey].(Request)
+	return request, ok
+}
+
+// WithValue returns a copy of err carrying val under key, in the manner of
+// context.WithValue. To avoid collisions between packages, keys should be of
+// an unexported type defined by the package using them. Values are not shown
+// by Error, ErrorStack or MarshalJSON. The key must be comparable.
+func (err *Error) WithValue(key, val interface{}) *Error {
+	if key == nil {
+		panic("nil key")
+	}
+	if !reflect.TypeOf(key).Comparable() {
+		panic("key is not comparable")
+	}
+
+	copied := *err
+	copied.values = make(map[interface{}]interface{}, len(err.values)+1)
+	for k, v := range err.values {
+		copied.values[k] = v
+	}
+	copied.values[key] = val
+	return &copied
+}
+
+// Value returns the value attached by WithValue under key to the first *Error
+// in err's chain that has one, or nil if there is none.
+func (err *Error) Value(key interface{}) interface{} {
+	var value interface{}
+
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			var found bool
+			value, found = e.values[key]
+			return !found
+		}
+		return true
+	})
+
+	return value
+}
+
+// Severity is the importance of an error, with greater values more severe.
+// The zero value is SeverityError, the severity of errors without one.
+type Severity int
+
+const (
+	// SeverityInfo is for errors that are expected and need no action.
+	SeverityInfo Severity = -2
+	// SeverityWarning is for errors that were handled but may need attention.
+	SeverityWarning Severity = -1
+	// SeverityError is for errors that caused an operation to fail.
+	SeverityError Severity = 0
+	// SeverityCritical is for errors that need immediate attention.
+	SeverityCritical Severity = 1
+)
+
+// WithSeverity returns a copy of err with the given severity.
+func (err *Error) WithSeverity(severity Severity) *Error {
+	return err.withMetadata(severityKey, severity)
+}
+
+// SeverityOf returns the severity set by WithSeverity on the first *Error in
+// err's chain that has one, or SeverityError if there is none.
+func SeverityOf(err error) Severity {
+	severity := SeverityError
+
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			var found bool
+			severity, found = e.metadata[severityKey].(Severity)
+			return !found
+		}
+		return true
+	})
+
+	return severity
+}
+
+// SortBySeverity sorts errs in place from the most to the least severe,
+// according to SeverityOf. Errors of equal severity keep their order.
+func SortBySeverity(errs []error) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		return SeverityOf(errs[i]) > SeverityOf(errs[j])
+	})
+}
+
+// SQLQuery is a database query that produced an error.
+type SQLQuery struct {
+	SQL  string        `json:"sql"`
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// RedactQueryArgs, if set, is called by WithQuery to replace the arguments of
+// a query before they are stored, so that sensitive values are never logged.
+// It must not modify args.
+var RedactQueryArgs func(query string, args []interface{}) []interface{}
+
+// WithQuery returns a copy of err recording the SQL query, and its arguments,
+// that produced it. The query is shown in ErrorStack below the message and
+// included in MarshalJSON. The arguments are passed through RedactQueryArgs if
+// it is set.
+func (err *Error) WithQuery(query string, args ...interface{}) *Error {
+	if RedactQueryArgs != nil {
+		args = RedactQueryArgs(query, args)
+	}
+	return err.withMetadata(queryKey, SQLQuery{SQL: query, Args: args})
+}
+
+// Query returns the query recorded by WithQuery. The second result reports
+// whether one was recorded.
+func (err *Error) Query() (SQLQuery, bool) {
+	query, ok := err.metadata[queryKey].(SQLQuery)
+	return query, ok
+}
+
+// queryString formats the query for ErrorStack.
+func (err *Error) queryString() string {
+	query, ok := err.Query()
+	if !ok {
+		return ""
+	}
+	if len(query.Args) == 0 {
+		return "query: " + query.SQL + "\n"
+	}
+	return fmt.Sprintf("query: %s %v\n", query.SQL, query.Args)
+}
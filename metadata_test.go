@@ -0,0 +1,331 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewWithEnv(t *testing.T) {
+	err := NewWithEnv("foo").(*Error)
+
+	env, ok := err.Metadata()["env"].(Env)
+	if !ok {
+		t.Fatalf("Env not in metadata: %v", err.Metadata())
+	}
+	if env.GOOS != runtime.GOOS || env.GOARCH != runtime.GOARCH || env.GoVersion != runtime.Version() {
+		t.Errorf("Wrong env: %#v", env)
+	}
+
+	if !strings.HasPrefix(err.StackFrames()[0].Name, "TestNewWithEnv") {
+		t.Errorf("Wrong top frame: %s", err.StackFrames()[0].Name)
+	}
+
+	if !strings.HasSuffix(err.ErrorStack(), env.String()) {
+		t.Errorf("ErrorStack does not include env:\n%s", err.ErrorStack())
+	}
+
+	data, jsonErr := json.Marshal(err)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if !strings.Contains(string(data), `"metadata":{"env":{"goos":"`+runtime.GOOS+`"`) {
+		t.Errorf("MarshalJSON does not include env: %s", data)
+	}
+
+	if _, ok := New("foo").(*Error).Metadata()["env"]; ok {
+		t.Errorf("New captured env")
+	}
+}
+
+type temporaryError bool
+
+func (e temporaryError) Error() string {
+	return "temporary"
+}
+
+func (e temporaryError) Temporary() bool {
+	return bool(e)
+}
+
+func TestTemporary(t *testing.T) {
+	if Temporary(nil) || Temporary(io.EOF) || New(io.EOF).(*Error).Temporary() {
+		t.Errorf("Error without signal is temporary")
+	}
+
+	if !Temporary(fmt.Errorf("wrapped: %w", New(temporaryError(true)))) {
+		t.Errorf("Temporary interface not detected")
+	}
+
+	if !New(io.EOF).(*Error).WithTemporary(true).Temporary() {
+		t.Errorf("Temporary flag not detected")
+	}
+
+	permanent := New(temporaryError(true)).(*Error).WithTemporary(false)
+	if Temporary(permanent) {
+		t.Errorf("Temporary flag does not take precedence")
+	}
+
+	flagged := New(errors.Join(io.EOF, temporaryError(true))).(*Error)
+	if !Temporary(flagged) {
+		t.Errorf("Temporary not detected through Join")
+	}
+	if _, ok := flagged.WithTemporary(false).Metadata()["temporary"]; !ok || flagged.Metadata()["temporary"] != nil {
+		t.Errorf("WithTemporary modified the original error")
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	if retryable, ok := Retryable(New(io.EOF)); retryable || ok {
+		t.Errorf("Retryable flag found without being set")
+	}
+
+	inner := New(io.EOF).(*Error).WithRetryable(false)
+	if retryable, ok := Retryable(fmt.Errorf("wrapped: %w", inner)); retryable || !ok {
+		t.Errorf("Retryable flag not found")
+	}
+
+	outer := New(inner).(*Error).WithRetryable(true)
+	if retryable, ok := Retryable(outer); !retryable || !ok {
+		t.Errorf("First Retryable flag not used")
+	}
+}
+
+func TestWrapCode(t *testing.T) {
+	if WrapCode(nil, 404, "not found") != nil {
+		t.Errorf("Wrapped nil error not nil")
+	}
+
+	inner := New(io.EOF)
+	err, expected := WrapCode(inner, 404, "not found"), callers()
+
+	e := err.(*Error)
+	if e.Err != inner {
+		t.Errorf("*Error was not wrapped")
+	}
+	if err := compareStacks(e.stack, expected); err != nil {
+		t.Errorf("Stack didn't match")
+		t.Errorf(err.Error())
+	}
+
+	if err.Error() != "not found: EOF" || e.BaseMessage() != "EOF" {
+		t.Errorf("Wrong messages: %s, %s", err.Error(), e.BaseMessage())
+	}
+
+	if code, ok := CodeOf(fmt.Errorf("wrapped: %w", err)); code != 404 || !ok {
+		t.Errorf("Wrong code: %d, %v", code, ok)
+	}
+	if _, ok := CodeOf(inner); ok {
+		t.Errorf("Code found without being set")
+	}
+}
+
+func TestWithSnapshot(t *testing.T) {
+	values := map[string]interface{}{"id": 42, "name": "bob"}
+	err := New("foo").(*Error).WithSnapshot(values)
+	values["id"] = 0
+
+	frames := err.StackFrames()
+	expected := frames[0].String() + "\t\tid = 42\n\t\tname = \"bob\"\n" + frames[1].String()
+	if !strings.Contains(err.ErrorStack(), expected) {
+		t.Errorf("Snapshot not shown below the top frame:\n%s", err.ErrorStack())
+	}
+
+	if strings.Contains(string(err.Stack()), "bob") {
+		t.Errorf("Snapshot shown in Stack")
+	}
+
+	data, jsonErr := json.Marshal(err)
+	if jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if !strings.Contains(string(data), `"metadata":{"snapshot":{"id":42,"name":"bob"}}`) {
+		t.Errorf("MarshalJSON does not include snapshot: %s", data)
+	}
+}
+
+func TestWrapPrefixMetadata(t *testing.T) {
+	original := WrapCode(io.EOF, 500, "internal").(*Error).WithRetryable(true)
+	prefixed := WrapPrefix(original, "prefix", 0).(*Error)
+
+	if code, ok := CodeOf(prefixed); code != 500 || !ok {
+		t.Errorf("Code dropped by WrapPrefix")
+	}
+	if retryable, ok := Retryable(prefixed); !retryable || !ok {
+		t.Errorf("Retryable flag dropped by WrapPrefix")
+	}
+	if prefixed.Error() != "prefix: internal: EOF" || original.Error() != "internal: EOF" {
+		t.Errorf("Wrong messages: %s, %s", prefixed.Error(), original.Error())
+	}
+}
+
+func TestGoroutineHops(t *testing.T) {
+	if WrapAcross(nil, 0) != nil {
+		t.Errorf("Wrapped nil error not nil")
+	}
+
+	errs := make(chan error)
+	go func() {
+		errs <- New(io.EOF)
+	}()
+	received := <-errs
+
+	if received.(*Error).GoroutineHops() != 0 {
+		t.Errorf("Hops recorded without crossing")
+	}
+
+	once := WrapAcross(received, 0).(*Error)
+	if once.GoroutineHops() != 1 || received.(*Error).GoroutineHops() != 0 {
+		t.Errorf("Wrong hops after one crossing: %d", once.GoroutineHops())
+	}
+
+	twice := WrapAcross(fmt.Errorf("forwarded: %w", once), 0).(*Error)
+	if twice.GoroutineHops() != 2 {
+		t.Errorf("Wrong hops after two crossings: %d", twice.GoroutineHops())
+	}
+}
+
+func TestAlreadyLogged(t *testing.T) {
+	err := New(io.EOF).(*Error)
+	if AlreadyLogged(err) || AlreadyLogged(nil) {
+		t.Errorf("Error logged without being marked")
+	}
+
+	logged := err.WithLogged()
+	if !AlreadyLogged(logged) || AlreadyLogged(err) {
+		t.Errorf("Wrong logged marks")
+	}
+
+	if !AlreadyLogged(Errorf("outer: %w", logged)) || !AlreadyLogged(WrapPrefix(logged, "prefix", 0)) {
+		t.Errorf("Logged mark lost by wrapping")
+	}
+}
+
+func TestUserMessage(t *testing.T) {
+	if _, ok := UserMessage(New(io.EOF)); ok {
+		t.Errorf("User message found without being set")
+	}
+
+	inner := New(io.EOF).(*Error).WithUserMessage("Please try again")
+	outer := Errorf("loading profile: %w", inner).(*Error)
+
+	if message, ok := UserMessage(outer); message != "Please try again" || !ok {
+		t.Errorf("Wrong user message: %s", message)
+	}
+	if outer.Error() != "loading profile: EOF" {
+		t.Errorf("User message changed Error: %s", outer.Error())
+	}
+
+	nearest := WrapPrefix(outer, "handler", 0).(*Error).WithUserMessage("Profile unavailable")
+	if message, _ := UserMessage(nearest); message != "Profile unavailable" {
+		t.Errorf("Nearest user message not used: %s", message)
+	}
+}
+
+func TestWithRequest(t *testing.T) {
+	err := New(io.EOF).(*Error)
+	if _, ok := err.RequestInfo(); ok {
+		t.Errorf("Request found without being set")
+	}
+
+	withRequest := err.WithRequest("GET", "/users/1", 500)
+	if request, ok := withRequest.RequestInfo(); !ok || request != (Request{Method: "GET", Path: "/users/1", Status: 500}) {
+		t.Errorf("Wrong request: %v", request)
+	}
+	if _, ok := err.RequestInfo(); ok {
+		t.Errorf("WithRequest modified the original error")
+	}
+
+	data, e := json.Marshal(withRequest)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(string(data), `"request":{"method":"GET","path":"/users/1","status":500}`) {
+		t.Errorf("Request missing from JSON: %s", data)
+	}
+}
+
+type testKey string
+
+func TestWithValue(t *testing.T) {
+	inner := New(io.EOF).(*Error).WithValue(testKey("tenant"), "acme")
+	outer := Errorf("handler: %w", inner).(*Error).WithValue(testKey("request"), 42)
+
+	if v := outer.Value(testKey("tenant")); v != "acme" {
+		t.Errorf("Wrong inner value: %v", v)
+	}
+	if v := outer.Value(testKey("request")); v != 42 {
+		t.Errorf("Wrong outer value: %v", v)
+	}
+	if v := outer.Value("tenant"); v != nil {
+		t.Errorf("Untyped key matched: %v", v)
+	}
+	if v := inner.Value(testKey("request")); v != nil {
+		t.Errorf("Value found on inner error: %v", v)
+	}
+
+	nearest := outer.WithValue(testKey("tenant"), "other")
+	if v := nearest.Value(testKey("tenant")); v != "other" {
+		t.Errorf("Nearest value not used: %v", v)
+	}
+
+	data, _ := json.Marshal(nearest)
+	if nearest.Error() != "handler: EOF" || strings.Contains(string(data), "acme") {
+		t.Errorf("Value included in output: %s", data)
+	}
+}
+
+func TestSortBySeverity(t *testing.T) {
+	info := New("info").(*Error).WithSeverity(SeverityInfo)
+	critical := Errorf("wrapped: %w", New("critical").(*Error).WithSeverity(SeverityCritical))
+	plain := io.EOF
+	warning := New("warning").(*Error).WithSeverity(SeverityWarning)
+	unset := New("unset")
+
+	if SeverityOf(critical) != SeverityCritical || SeverityOf(plain) != SeverityError {
+		t.Errorf("Wrong severities")
+	}
+
+	errs := []error{info, critical, plain, warning, unset}
+	SortBySeverity(errs)
+
+	expected := []error{critical, plain, unset, warning, info}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Wrong order: %v", errs)
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	defer func(redact func(string, []interface{}) []interface{}) { RedactQueryArgs = redact }(RedactQueryArgs)
+
+	err := New(io.EOF).(*Error)
+	if _, ok := err.Query(); ok || strings.Contains(err.ErrorStack(), "query:") {
+		t.Errorf("Query found without being set")
+	}
+
+	withQuery := err.WithQuery("SELECT * FROM users WHERE id = ?", 42)
+	if query, ok := withQuery.Query(); !ok || query.SQL != "SELECT * FROM users WHERE id = ?" || !reflect.DeepEqual(query.Args, []interface{}{42}) {
+		t.Errorf("Wrong query: %v", query)
+	}
+	if !strings.HasPrefix(withQuery.ErrorStack(), "*errors.errorString EOF\nquery: SELECT * FROM users WHERE id = ? [42]\n") {
+		t.Errorf("Query not in ErrorStack:\n%s", withQuery.ErrorStack())
+	}
+
+	RedactQueryArgs = func(query string, args []interface{}) []interface{} {
+		redacted := make([]interface{}, len(args))
+		for i := range args {
+			redacted[i] = "?"
+		}
+		return redacted
+	}
+	redacted := err.WithQuery("UPDATE users SET password = ?", "hunter2")
+	data, _ := json.Marshal(redacted)
+	if strings.Contains(redacted.ErrorStack(), "hunter2") || strings.Contains(string(data), "hunter2") {
+		t.Errorf("Argument not redacted: %s", data)
+	}
+}
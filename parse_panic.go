@@ -1,6 +1,7 @@
 package errors
 
 import (
+	baseErrors "errors"
 	"strconv"
 	"strings"
 )
@@ -11,6 +12,24 @@ func (p uncaughtPanic) Error() string {
 	return p.message
 }
 
+// IsOrPanic reports whether errors.Is(err, target), or whether err's chain
+// contains a panic parsed by ParsePanic whose message is that of target. A
+// parsed panic only records the text of the panic value, so this is how a
+// panic with a known error value is matched.
+func IsOrPanic(err, target error) bool {
+	if baseErrors.Is(err, target) {
+		return true
+	}
+	if target == nil {
+		return false
+	}
+
+	return !walk(err, func(e error) bool {
+		p, ok := e.(uncaughtPanic)
+		return !ok || strings.TrimSuffix(p.message, " [recovered]") != target.Error()
+	})
+}
+
 // ParsePanic allows you to get an error object from the output of a go program
 // that panicked. This is particularly useful with https://github.com/mitchellh/panicwrap.
 func ParsePanic(text string) (*Error, error) {
@@ -73,6 +92,42 @@ func ParsePanic(text string) (*Error, error) {
 	return nil, Errorf("could not parse panic: %v", text)
 }
 
+// NewFromStackText makes an Error with the message msg and a stack captured
+// elsewhere as text, in the format of runtime.Stack or debug.Stack, e.g. from
+// another goroutine. Stack and ErrorStack show the text as given. Frames of the
+// first goroutine are parsed from it on a best-effort basis for StackFrames;
+// lines that cannot be parsed are skipped.
+func NewFromStackText(msg string, stackText string) *Error {
+	if stackText != "" && !strings.HasSuffix(stackText, "\n") {
+		stackText += "\n"
+	}
+
+	frames := []StackFrame{}
+	lines := strings.Split(stackText, "\n")
+	for i := 0; i < len(lines)-1; i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		if line == "" {
+			if len(frames) > 0 {
+				break
+			}
+			continue
+		}
+
+		createdBy := strings.HasPrefix(line, "created by ")
+		frame, err := parsePanicFrame(strings.TrimPrefix(line, "created by "), lines[i+1], createdBy)
+		if err != nil {
+			continue
+		}
+		frames = append(frames, *frame)
+		i++
+	}
+
+	return &Error{Err: baseErrors.New(msg), frames: frames, text: stackText}
+}
+
 // The lines we're passing look like this:
 //
 //     main.(*foo).destruct(0xc208067e98)
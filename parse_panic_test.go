@@ -1,6 +1,8 @@
 package errors
 
 import (
+	"errors"
+	"io"
 	"reflect"
 	"testing"
 )
@@ -140,3 +142,52 @@ func TestParsePanic(t *testing.T) {
 		}
 	}
 }
+
+func TestIsOrPanic(t *testing.T) {
+	parsed, err := ParsePanic("panic: EOF [recovered]\n\ngoroutine 1 [running]:\nmain.main()\n\t/src/main.go:5 +0x1d\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errors.Is(parsed, io.EOF) || !IsOrPanic(parsed, io.EOF) {
+		t.Errorf("Panic with io.EOF not matched")
+	}
+	if IsOrPanic(parsed, io.ErrUnexpectedEOF) || IsOrPanic(parsed, nil) {
+		t.Errorf("Panic matched the wrong error")
+	}
+	if !IsOrPanic(New(io.EOF), io.EOF) || IsOrPanic(New(io.EOF), io.ErrUnexpectedEOF) {
+		t.Errorf("Error not matched with Is")
+	}
+}
+
+func TestNewFromStackText(t *testing.T) {
+	text := "goroutine 7 [running]:\n" +
+		"main.(*worker).run(0xc000010000)\n" +
+		"\t/src/app/worker.go:42 +0x1d\n" +
+		"created by main.main\n" +
+		"\t/src/app/main.go:10 +0x25\n" +
+		"\n" +
+		"goroutine 1 [chan receive]:\n" +
+		"main.main()\n" +
+		"\t/src/app/main.go:12 +0x3a\n"
+
+	err := NewFromStackText("worker stalled", text)
+	if err.Error() != "worker stalled" {
+		t.Errorf("Wrong message: %s", err.Error())
+	}
+	if err.ErrorStack() != "*errors.errorString worker stalled\n"+text {
+		t.Errorf("Wrong stack:\n%s", err.ErrorStack())
+	}
+
+	expected := []StackFrame{
+		{File: "/src/app/worker.go", LineNumber: 42, Name: "(*worker).run", Package: "main"},
+		{File: "/src/app/main.go", LineNumber: 10, Name: "main", Package: "main"},
+	}
+	if !reflect.DeepEqual(err.StackFrames(), expected) {
+		t.Errorf("Wrong frames: %#v", err.StackFrames())
+	}
+
+	if frames := NewFromStackText("garbled", "not a stack").StackFrames(); len(frames) != 0 {
+		t.Errorf("Frames parsed from garbage: %#v", frames)
+	}
+}
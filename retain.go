@@ -0,0 +1,90 @@
+package errors
+
+import "sync"
+
+// A Retainer keeps the most recent errors given to it, e.g. for a debug page,
+// within a budget of stack frames. Errors are compacted when retained, so they
+// hold no program counters, and the oldest are evicted once the frames of the
+// retained errors exceed MaxFrames. It is safe for concurrent use.
+type Retainer struct {
+	// MaxFrames is the budget of frames, including those of stacks added
+	// with AddStack, held by the retained errors and the *Errors they wrap.
+	// Zero means no limit.
+	MaxFrames int
+
+	mu     sync.Mutex
+	errs   []error
+	frames []int
+	total  int
+}
+
+// Retain adds err to the retained errors, evicting the oldest as needed to
+// stay within MaxFrames. The frames of every *Error in err's chain, including
+// the branches of a Join, count against MaxFrames. An *Error is retained as its
+// Compact copy, but *Errors further down the chain, e.g. wrapped with
+// fmt.Errorf("%w"), can't be replaced without modifying the errors wrapping
+// them, so are retained with their program counters. Nil errors are ignored.
+func (r *Retainer) Retain(err error) {
+	if err == nil {
+		return
+	}
+
+	if e, ok := err.(*Error); ok {
+		err = e.Compact()
+	}
+	frames := 0
+	walk(err, func(e error) bool {
+		if e, ok := e.(*Error); ok {
+			frames += e.heldFrames()
+		}
+		return true
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errs = append(r.errs, err)
+	r.frames = append(r.frames, frames)
+	r.total += frames
+
+	evict := 0
+	for r.MaxFrames > 0 && r.total > r.MaxFrames {
+		r.total -= r.frames[evict]
+		evict++
+	}
+	if evict > 0 {
+		r.errs = append(r.errs[:0:0], r.errs[evict:]...)
+		r.frames = append(r.frames[:0:0], r.frames[evict:]...)
+	}
+}
+
+// Errors returns the retained errors, oldest first.
+func (r *Retainer) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]error(nil), r.errs...)
+}
+
+// Frames returns the number of frames held by the retained errors, the usage
+// counted against MaxFrames.
+func (r *Retainer) Frames() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.total
+}
+
+// heldFrames returns the number of frames or program counters held by the
+// stacks of err, including those added with AddStack.
+func (err *Error) heldFrames() int {
+	frames := err.StackDepth()
+	for _, s := range err.labeled {
+		if s.stack != nil {
+			frames += len(s.stack)
+		} else {
+			frames += len(s.frames)
+		}
+	}
+	return frames
+}
@@ -0,0 +1,60 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestRetainer(t *testing.T) {
+	stack := func(n int) *Error {
+		return &Error{Err: io.EOF, frames: make([]StackFrame, n)}
+	}
+
+	r := &Retainer{MaxFrames: 10}
+	r.Retain(nil)
+	r.Retain(stack(4))
+	r.Retain(io.ErrClosedPipe)
+	r.Retain(stack(5))
+
+	if r.Frames() != 9 || len(r.Errors()) != 3 {
+		t.Errorf("Wrong usage: %d frames in %d errors", r.Frames(), len(r.Errors()))
+	}
+
+	r.Retain(stack(3))
+	if errs := r.Errors(); r.Frames() != 8 || len(errs) != 3 || errs[0] != io.ErrClosedPipe {
+		t.Errorf("Oldest not evicted: %d frames in %v", r.Frames(), errs)
+	}
+
+	unlimited := &Retainer{}
+	err := New(io.EOF).(*Error).AddStack("retained")
+	unlimited.Retain(err)
+	unlimited.Retain(err)
+
+	retained := unlimited.Errors()[0].(*Error)
+	if retained.stack != nil || retained.labeled[0].stack != nil {
+		t.Errorf("Retained error not compacted")
+	}
+	if frames := len(err.StackFrames()) + len(err.LabeledStacks()["retained"]); unlimited.Frames() != 2*frames {
+		t.Errorf("Wrong usage: %d frames", unlimited.Frames())
+	}
+}
+
+func TestRetainerChain(t *testing.T) {
+	stack := func(n int) *Error {
+		return &Error{Err: io.EOF, frames: make([]StackFrame, n)}
+	}
+
+	r := &Retainer{MaxFrames: 10}
+	r.Retain(fmt.Errorf("wrapped: %w", stack(4)))
+	r.Retain(errors.Join(stack(2), stack(3)))
+	if r.Frames() != 9 || len(r.Errors()) != 2 {
+		t.Errorf("Wrong usage: %d frames in %d errors", r.Frames(), len(r.Errors()))
+	}
+
+	r.Retain(&Error{Err: stack(2), frames: make([]StackFrame, 1)})
+	if r.Frames() != 8 || len(r.Errors()) != 2 {
+		t.Errorf("Nested frames not counted: %d frames in %d errors", r.Frames(), len(r.Errors()))
+	}
+}
@@ -7,8 +7,43 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// TrimFilePathPrefix is stripped from the start of file paths when frames are
+// formatted by String. Set it to the module root to get relative paths in
+// logs. The default is empty, meaning no trimming.
+var TrimFilePathPrefix = ""
+
+// Redactor, if set, scrubs sensitive text such as tokens or personal data. It
+// is applied to the result of Error.Error, and to the file paths and package
+// names of frames wherever they are output, e.g. by String, ErrorStack and
+// MarshalJSON. It runs on every such call, so it should be fast. The default is
+// nil, leaving text unchanged.
+var Redactor func(string) string
+
+// FrameFormat selects how StackFrame.String formats a frame.
+type FrameFormat int
+
+const (
+	// FormatGo formats frames the same way as go does in runtime/debug.Stack()
+	FormatGo FrameFormat = iota
+	// FormatIDE formats frames as "/full/path/file.go:42 package.Func" on a
+	// single line, which IDEs such as Goland recognise as a clickable link.
+	FormatIDE
+	// FormatVSCode formats frames as "path/file.go:42:1 package.Func" on a
+	// single line, matching the "file:line:col" form of VS Code problem
+	// matchers. Go does not record columns, so the column is always 1. Set
+	// TrimFilePathPrefix to the workspace root to get relative paths.
+	FormatVSCode
+)
+
+// StackFrameFormat is the format used by StackFrame.String, and so by
+// Error.Stack and Error.ErrorStack. The default is FormatGo.
+var StackFrameFormat = FormatGo
+
 // A StackFrame contains all necessary information about to generate a line
 // in a callstack.
 type StackFrame struct {
@@ -24,14 +59,27 @@ type StackFrame struct {
 	ProgramCounter uintptr
 }
 
+// Symbolizer, if set, is used by NewStackFrame instead of the runtime to
+// resolve a program counter to its function name (including the package path,
+// as returned by runtime.Func.Name), file and line. The program counter is a
+// return address, as captured by runtime.Callers. This allows readable stacks
+// from stripped binaries using a separately shipped symbol map.
+var Symbolizer func(pc uintptr) (funcName, file string, line int)
+
 // NewStackFrame popoulates a stack frame object from the program counter.
 func NewStackFrame(pc uintptr) (frame StackFrame) {
 
 	frame = StackFrame{ProgramCounter: pc}
+	if Symbolizer != nil {
+		var funcName string
+		funcName, frame.File, frame.LineNumber = Symbolizer(pc)
+		frame.Package, frame.Name = packageAndName(funcName)
+		return
+	}
 	if frame.Func() == nil {
 		return
 	}
-	frame.Package, frame.Name = packageAndName(frame.Func())
+	frame.Package, frame.Name = packageAndName(frame.Func().Name())
 
 	// pc -1 because the program counters we use are usually return addresses,
 	// and we want to show the line that corresponds to the function call
@@ -40,6 +88,46 @@ func NewStackFrame(pc uintptr) (frame StackFrame) {
 
 }
 
+// resolveFrames symbolizes the program counters captured by runtime.Callers in
+// a single pass with runtime.CallersFrames. Inlined calls are expanded into
+// their own frames, so there may be more frames than program counters. If
+// Symbolizer is set each program counter is resolved with NewStackFrame
+// instead.
+func resolveFrames(pcs []uintptr) []StackFrame {
+	if TrackStats {
+		defer track(&stats.symbolizations, &stats.symbolizeNanos, time.Now())
+	}
+
+	frames := make([]StackFrame, 0, len(pcs))
+
+	if Symbolizer != nil {
+		for _, pc := range pcs {
+			frames = append(frames, NewStackFrame(pc))
+		}
+		return frames
+	}
+	if len(pcs) == 0 {
+		return frames
+	}
+
+	callers := runtime.CallersFrames(pcs)
+	for {
+		f, more := callers.Next()
+		frames = append(frames, runtimeFrame(f))
+
+		if !more {
+			return frames
+		}
+	}
+}
+
+// runtimeFrame converts a frame returned by runtime.Frames.
+func runtimeFrame(f runtime.Frame) StackFrame {
+	frame := StackFrame{File: f.File, LineNumber: f.Line, ProgramCounter: f.PC}
+	frame.Package, frame.Name = packageAndName(f.Function)
+	return frame
+}
+
 // Func returns the function that contained this frame.
 func (frame *StackFrame) Func() *runtime.Func {
 	if frame.ProgramCounter == 0 {
@@ -48,10 +136,17 @@ func (frame *StackFrame) Func() *runtime.Func {
 	return runtime.FuncForPC(frame.ProgramCounter)
 }
 
-// String returns the stackframe formatted in the same way as go does
-// in runtime/debug.Stack()
+// String returns the stackframe formatted according to StackFrameFormat. By
+// default this is the same way as go does in runtime/debug.Stack()
 func (frame *StackFrame) String() string {
-	str := fmt.Sprintf("%s:%d (0x%x)\n", frame.File, frame.LineNumber, frame.ProgramCounter)
+	switch StackFrameFormat {
+	case FormatIDE:
+		return fmt.Sprintf("%s:%d %s.%s\n", frame.displayFile(), frame.LineNumber, redact(frame.Package), frame.Name)
+	case FormatVSCode:
+		return fmt.Sprintf("%s:%d:1 %s.%s\n", frame.displayFile(), frame.LineNumber, redact(frame.Package), frame.Name)
+	}
+
+	str := fmt.Sprintf("%s:%d (0x%x)\n", frame.displayFile(), frame.LineNumber, frame.ProgramCounter)
 
 	source, err := frame.sourceLine()
 	if err != nil {
@@ -61,6 +156,53 @@ func (frame *StackFrame) String() string {
 	return str + fmt.Sprintf("\t%s: %s\n", frame.Name, source)
 }
 
+// IsExported reports whether the frame's function is exported, based on the
+// first letter of its name with any receiver stripped. Closures are reported
+// as unexported.
+func (frame *StackFrame) IsExported() bool {
+	name := frame.Name
+	if strings.HasSuffix(name, "]") {
+		// type parameters of a generic function, e.g. Map[...]
+		if i := strings.LastIndex(name, "["); i >= 0 {
+			name = name[:i]
+		}
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// displayFile returns the file path with TrimFilePathPrefix removed, passed
+// through Redactor if it is set. Every file path that is output goes through
+// displayFile.
+func (frame *StackFrame) displayFile() string {
+	return redact(strings.TrimPrefix(frame.File, TrimFilePathPrefix))
+}
+
+// redact returns s passed through Redactor if it is set.
+func redact(s string) string {
+	if Redactor != nil {
+		return Redactor(s)
+	}
+	return s
+}
+
+// matches reports whether the marker names this frame's function, either by
+// its Name or by its fully qualified Package.Name.
+func (frame *StackFrame) matches(marker string) bool {
+	return marker == frame.Name || marker == frame.Package+"."+frame.Name
+}
+
+// sameLocation reports whether both frames refer to the same file, line and
+// function.
+func (frame *StackFrame) sameLocation(other *StackFrame) bool {
+	return frame.File == other.File && frame.LineNumber == other.LineNumber &&
+		frame.Package == other.Package && frame.Name == other.Name
+}
+
 // SourceLine gets the line of code (from File and Line) of the original source if possible.
 func (frame *StackFrame) SourceLine() (string, error) {
 	source, err := frame.sourceLine()
@@ -96,8 +238,7 @@ func (frame *StackFrame) sourceLine() (string, error) {
 	return "???", nil
 }
 
-func packageAndName(fn *runtime.Func) (string, string) {
-	name := fn.Name()
+func packageAndName(name string) (string, string) {
 	pkg := ""
 
 	// The name includes the path name to the package, which is unnecessary
@@ -108,13 +249,17 @@ func packageAndName(fn *runtime.Func) (string, string) {
 	//  *T.ptrmethod
 	// Since the package path might contains dots (e.g. code.google.com/...),
 	// we first remove the path prefix if there is one.
+	// pkg and name are sliced from the original name to avoid allocating.
+	start := 0
 	if lastslash := strings.LastIndex(name, "/"); lastslash >= 0 {
-		pkg += name[:lastslash] + "/"
-		name = name[lastslash+1:]
+		start = lastslash + 1
 	}
-	if period := strings.Index(name, "."); period >= 0 {
-		pkg += name[:period]
-		name = name[period+1:]
+	if period := strings.Index(name[start:], "."); period >= 0 {
+		pkg = name[:start+period]
+		name = name[start+period+1:]
+	} else {
+		pkg = name[:start]
+		name = name[start:]
 	}
 
 	name = strings.Replace(name, "·", ".", -1)
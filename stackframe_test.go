@@ -0,0 +1,171 @@
+package errors
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTrimFilePathPrefix(t *testing.T) {
+	defer func(prefix string) { TrimFilePathPrefix = prefix }(TrimFilePathPrefix)
+
+	frame := New("foo").(*Error).StackFrames()[0]
+	_, file, _, _ := runtime.Caller(0)
+	TrimFilePathPrefix = filepath.Dir(file) + "/"
+
+	str := frame.String()
+	if !strings.HasPrefix(str, "stackframe_test.go:") {
+		t.Errorf("File path was not trimmed: %s", str)
+	}
+	if !strings.Contains(str, "TestTrimFilePathPrefix: frame := New(\"foo\")") {
+		t.Errorf("Source line was not found: %s", str)
+	}
+
+	TrimFilePathPrefix = ""
+	if frame.String() == str {
+		t.Errorf("File path was trimmed without a prefix")
+	}
+}
+
+func TestStackFrameFormatIDE(t *testing.T) {
+	defer func(format FrameFormat) { StackFrameFormat = format }(StackFrameFormat)
+
+	frame := StackFrame{File: "/full/path/file.go", LineNumber: 42, Package: "example.com/pkg", Name: "Func"}
+
+	StackFrameFormat = FormatIDE
+	if str := frame.String(); str != "/full/path/file.go:42 example.com/pkg.Func\n" {
+		t.Errorf("Wrong IDE format: %q", str)
+	}
+
+	StackFrameFormat = FormatVSCode
+	if str := frame.String(); str != "/full/path/file.go:42:1 example.com/pkg.Func\n" {
+		t.Errorf("Wrong VS Code format: %q", str)
+	}
+
+	StackFrameFormat = FormatGo
+	if str := frame.String(); str != "/full/path/file.go:42 (0x0)\n" {
+		t.Errorf("Wrong go format: %q", str)
+	}
+}
+
+func TestSymbolizer(t *testing.T) {
+	defer func(symbolizer func(uintptr) (string, string, int)) { Symbolizer = symbolizer }(Symbolizer)
+
+	Symbolizer = func(pc uintptr) (string, string, int) {
+		return "example.com/app/pkg.(*T).Method", "/src/pkg/t.go", int(pc)
+	}
+
+	frame := NewStackFrame(42)
+	expected := StackFrame{File: "/src/pkg/t.go", LineNumber: 42, Name: "(*T).Method", Package: "example.com/app/pkg", ProgramCounter: 42}
+	if frame != expected {
+		t.Errorf("Wrong frame: %#v", frame)
+	}
+}
+
+func TestIsExported(t *testing.T) {
+	names := map[string]bool{
+		"Func":                 true,
+		"func":                 false,
+		"(*T).Method":          true,
+		"(*T).method":          false,
+		"t.Method":             true,
+		"(*List[...]).Push":    true,
+		"Map[...]":             true,
+		"mapKeys[...]":         false,
+		"Func.func1":           false,
+		"TestIsExported.func2": false,
+		"":                     false,
+	}
+
+	for name, exported := range names {
+		frame := StackFrame{Name: name}
+		if frame.IsExported() != exported {
+			t.Errorf("Wrong IsExported for %q", name)
+		}
+		if allocs := testing.AllocsPerRun(10, func() { frame.IsExported() }); allocs != 0 {
+			t.Errorf("IsExported allocated for %q", name)
+		}
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	defer func(redactor func(string) string) { Redactor = redactor }(Redactor)
+
+	err := &Error{Err: errorString("token=secret"), frames: []StackFrame{
+		{File: "/home/alice/src/main.go", LineNumber: 12, Package: "main", Name: "main"},
+	}}
+
+	Redactor = strings.NewReplacer("secret", "***", "alice", "***").Replace
+	if err.Error() != "token=***" {
+		t.Errorf("Message not redacted: %s", err.Error())
+	}
+	if stack := err.ErrorStack(); strings.Contains(stack, "secret") || strings.Contains(stack, "alice") {
+		t.Errorf("Stack not redacted:\n%s", stack)
+	}
+	if data, _ := json.Marshal(err); strings.Contains(string(data), "alice") || !strings.Contains(string(data), "/home/***/src/main.go") {
+		t.Errorf("JSON not redacted: %s", data)
+	}
+
+	defer func(group bool) { GroupByPackage = group }(GroupByPackage)
+	GroupByPackage = true
+	grouped := &Error{Err: errorString("foo"), frames: []StackFrame{
+		{File: "/src/alice.go", LineNumber: 12, Package: "github.com/alice/app", Name: "main"},
+	}}
+	if stack := grouped.ErrorStack(); strings.Contains(stack, "alice") {
+		t.Errorf("Grouped stack not redacted:\n%s", stack)
+	}
+
+	text := NewFromStackText("stalled", "goroutine 7 [running]:\nmain.run()\n\t/home/alice/src/main.go:42 +0x1d\n")
+	text.creator = "alice.startWorkers"
+	if stack := text.ErrorStack(); strings.Contains(stack, "alice") {
+		t.Errorf("Stack text not redacted:\n%s", stack)
+	}
+	if stack, _ := io.ReadAll(text.StackReader()); strings.Contains(string(stack), "alice") {
+		t.Errorf("Stack reader not redacted:\n%s", stack)
+	}
+
+	Redactor = nil
+	if err.Error() != "token=secret" {
+		t.Errorf("Message redacted without Redactor: %s", err.Error())
+	}
+}
+
+// stackHere captures the callers of stackHere.
+//
+//go:noinline
+func stackHere() []uintptr {
+	pcs := make([]uintptr, 10)
+	return pcs[:runtime.Callers(1, pcs)]
+}
+
+// inlinedCallers is small enough to be inlined into its caller, so its frame
+// and its caller's share a program counter.
+func inlinedCallers() []uintptr {
+	return stackHere()
+}
+
+func TestResolveFramesInlined(t *testing.T) {
+	pcs := inlinedCallers()
+	frames := resolveFrames(pcs)
+
+	var expected []StackFrame
+	callers := runtime.CallersFrames(pcs)
+	for {
+		f, more := callers.Next()
+		expected = append(expected, runtimeFrame(f))
+		if !more {
+			break
+		}
+	}
+	if !reflect.DeepEqual(frames, expected) {
+		t.Errorf("Frames differ from runtime.CallersFrames:\n%#v\n%#v", frames, expected)
+	}
+
+	if len(frames) < 3 || frames[1].Name != "inlinedCallers" || frames[2].Name != "TestResolveFramesInlined" {
+		t.Errorf("Inlined frame not expanded: %#v", frames)
+	}
+}
@@ -0,0 +1,81 @@
+package errors
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// TrackStats enables counting the stacktraces captured and symbolized, and the
+// time spent doing so, as reported by Stats. This helps quantify the cost of
+// errors before tuning MaxStackDepth or SampleRate. The default is false,
+// avoiding the cost of reading the clock.
+var TrackStats = false
+
+// CaptureStats is the cumulative cost of capturing and symbolizing stacktraces
+// since TrackStats was enabled.
+type CaptureStats struct {
+	// Captures is the number of stacktraces captured with runtime.Callers.
+	Captures int64
+	// CaptureTime is the time spent capturing them.
+	CaptureTime time.Duration
+	// Symbolizations is the number of stacktraces resolved to StackFrames.
+	Symbolizations int64
+	// SymbolizeTime is the time spent resolving them.
+	SymbolizeTime time.Duration
+}
+
+var stats struct {
+	captures       int64
+	captureNanos   int64
+	symbolizations int64
+	symbolizeNanos int64
+}
+
+// Stats returns the cost of capturing and symbolizing stacktraces while
+// TrackStats was set.
+func Stats() CaptureStats {
+	return CaptureStats{
+		Captures:       atomic.LoadInt64(&stats.captures),
+		CaptureTime:    time.Duration(atomic.LoadInt64(&stats.captureNanos)),
+		Symbolizations: atomic.LoadInt64(&stats.symbolizations),
+		SymbolizeTime:  time.Duration(atomic.LoadInt64(&stats.symbolizeNanos)),
+	}
+}
+
+// captureCallers fills stack with runtime.Callers, keeping the end of the
+// stack selected by StackTruncation, and counting the capture if TrackStats is
+// set. The skip parameter is as for runtime.Callers, relative to the caller of
+// captureCallers.
+func captureCallers(skip int, stack []uintptr) int {
+	if TrackStats {
+		defer track(&stats.captures, &stats.captureNanos, time.Now())
+	}
+
+	if len(stack) == 0 {
+		return 0
+	}
+
+	length := runtime.Callers(skip+1, stack)
+	if StackTruncation != KeepRoot || length < len(stack) {
+		return length
+	}
+
+	// the stack may be deeper than MaxStackDepth, so capture it whole and
+	// keep its outermost frames.
+	full := make([]uintptr, 2*len(stack))
+	for {
+		length = runtime.Callers(skip+1, full)
+		if length < len(full) {
+			break
+		}
+		full = make([]uintptr, 2*len(full))
+	}
+	return copy(stack, full[length-len(stack):length])
+}
+
+// track adds one to count and the time elapsed since start to nanos.
+func track(count, nanos *int64, start time.Time) {
+	atomic.AddInt64(count, 1)
+	atomic.AddInt64(nanos, int64(time.Since(start)))
+}
@@ -0,0 +1,27 @@
+package errors
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	defer func(track bool) { TrackStats = track }(TrackStats)
+
+	TrackStats = false
+	before := Stats()
+	New("foo").(*Error).StackFrames()
+	if Stats() != before {
+		t.Errorf("Stats tracked while disabled: %+v", Stats())
+	}
+
+	TrackStats = true
+	err := New("foo").(*Error)
+	err.StackFrames()
+	err.StackFrames()
+
+	after := Stats()
+	if after.Captures != before.Captures+1 || after.Symbolizations != before.Symbolizations+1 {
+		t.Errorf("Wrong counts: %+v", after)
+	}
+	if after.CaptureTime <= before.CaptureTime || after.SymbolizeTime <= before.SymbolizeTime {
+		t.Errorf("Time not tracked: %+v", after)
+	}
+}